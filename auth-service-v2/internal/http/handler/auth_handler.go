@@ -135,6 +135,22 @@ func (h *AuthHandler) Me(c *gin.Context) {
 
 }
 
+// StreamTicket issues a short-lived ticket that browsers pass as ?ticket= to
+// the SSE endpoints of the device and telemetry services.
+func (h *AuthHandler) StreamTicket(c *gin.Context) {
+	userID, exists := c.Get("owner_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": "false", "error": "unauthorized"})
+		return
+	}
+	ticket, ttl, err := h.svc.IssueStreamTicket(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": "false", "error": "internal"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": "true", "ticket": ticket, "expires_in": int(ttl.Seconds())})
+}
+
 func (h *AuthHandler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 }
@@ -27,6 +27,7 @@ func NewRouter(cfg *config.Config, userRepo repository.UserRepository) *ginpkg.E
 	auth.GET("/health", authHandler.HealthCheck)
 	auth.PUT("/me/password", authHandler.UpdatePassword)
 	auth.PUT("/me/name", authHandler.UpdateName)
+	auth.POST("/stream-tickets", authHandler.StreamTicket)
 
 	return r
 }
@@ -99,6 +99,12 @@ func (s *AuthService) VerifyToken(token string) (string, error) {
 	return claims.Subject, nil
 }
 
+// IssueStreamTicket returns a short-lived ticket for opening event streams
+// and how long it stays valid.
+func (s *AuthService) IssueStreamTicket(userID string) (string, time.Duration, error) {
+	return s.jwt.GenerateStreamTicket(userID)
+}
+
 func (s *AuthService) UpdateName(userID, newName string) error {
 	return s.repo.EditNameByID(userID, newName)
 }
@@ -10,9 +10,14 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// StreamTicketAudience marks stream tickets: short-lived tokens that may
+// only be used to open event streams, never as a bearer token.
+const StreamTicketAudience = "stream"
+
 type JWTManager struct {
-	secret string
-	ttl    time.Duration
+	secret    string
+	ttl       time.Duration
+	ticketTTL time.Duration
 }
 
 func NewJWTManagerFromEnv() *JWTManager {
@@ -26,11 +31,17 @@ func NewJWTManagerFromEnv() *JWTManager {
 			ttl = n
 		}
 	}
-	return &JWTManager{secret: secret, ttl: time.Minute * time.Duration(ttl)}
+	ticketTTL := 60
+	if v := os.Getenv("STREAM_TICKET_TTL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			ticketTTL = n
+		}
+	}
+	return &JWTManager{secret: secret, ttl: time.Minute * time.Duration(ttl), ticketTTL: time.Second * time.Duration(ticketTTL)}
 }
 
 func NewJWTManager(secret string, ttlMins int) *JWTManager {
-	return &JWTManager{secret: secret, ttl: time.Minute * time.Duration(ttlMins)}
+	return &JWTManager{secret: secret, ttl: time.Minute * time.Duration(ttlMins), ticketTTL: time.Minute}
 }
 
 type Claims struct {
@@ -52,7 +63,39 @@ func (j *JWTManager) Generate(userID string) (string, error) {
 	return token.SignedString([]byte(j.secret))
 }
 
+// GenerateStreamTicket issues a ticket that lets userID open event streams
+// for a short time. Browsers pass it as ?ticket= because EventSource cannot
+// set an Authorization header.
+func (j *JWTManager) GenerateStreamTicket(userID string) (string, time.Duration, error) {
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{StreamTicketAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.ticketTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "auth-service",
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(j.secret))
+	return signed, j.ticketTTL, err
+}
+
+// Verify checks a bearer token. Stream tickets are rejected.
 func (j *JWTManager) Verify(tokenStr string) (*Claims, error) {
+	claims, err := j.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if isStreamTicket(claims) {
+		return nil, errors.New("stream ticket used as bearer token")
+	}
+	return claims, nil
+}
+
+func (j *JWTManager) parse(tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -68,3 +111,12 @@ func (j *JWTManager) Verify(tokenStr string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+func isStreamTicket(claims *Claims) bool {
+	for _, aud := range claims.Audience {
+		if aud == StreamTicketAudience {
+			return true
+		}
+	}
+	return false
+}
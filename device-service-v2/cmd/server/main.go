@@ -7,7 +7,10 @@ import (
 
 	"github.com/DXR3IN/device-service-v2/internal/config"
 	"github.com/DXR3IN/device-service-v2/internal/http"
+	"github.com/DXR3IN/device-service-v2/internal/pubsub"
 	repo "github.com/DXR3IN/device-service-v2/internal/repository"
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/DXR3IN/device-service-v2/internal/storage"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -28,13 +31,60 @@ func main() {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&repo.Device{}); err != nil {
+	if err := repo.MigrateDeviceStatuses(db); err != nil {
+		log.Fatalf("failed to migrate device statuses: %v", err)
+	}
+	if err := db.AutoMigrate(
+		&repo.Device{},
+		&repo.DeviceStatusEvent{},
+		&repo.DeviceGroup{},
+		&repo.DeviceGroupMember{},
+		&repo.Command{},
+		&repo.DeviceShadow{},
+		&repo.Firmware{},
+		&repo.Campaign{},
+		&repo.CampaignDevice{},
+	); err != nil {
 		log.Fatalf("failed to migrate: %v", err)
 	}
 
-	deviceRepo := repo.NewDeviceRepository(db)
+	blobs, err := storage.NewLocalBlobStore(cfg.FirmwareDir)
+	if err != nil {
+		log.Fatalf("failed to open firmware store: %v", err)
+	}
+
+	if !service.IsValidSlowConsumerPolicy(cfg.SlowConsumerPolicy) {
+		log.Fatalf("unknown STREAM_SLOW_CONSUMER_POLICY %q", cfg.SlowConsumerPolicy)
+	}
+
+	// Postgres LISTEN/NOTIFY lets several replicas share stream events.
+	var backend service.BrokerBackend
+	switch cfg.BrokerBackend {
+	case "memory":
+	case "postgres":
+		pg, err := pubsub.NewPostgres(db, dsn, "device_stream_events")
+		if err != nil {
+			log.Fatalf("failed to start broker backend: %v", err)
+		}
+		backend = pg
+	default:
+		log.Fatalf("unknown BROKER_BACKEND %q", cfg.BrokerBackend)
+	}
+
+	repos := http.Repositories{
+		Devices:      repo.NewDeviceRepository(db),
+		StatusEvents: repo.NewStatusEventRepository(db),
+		Groups:       repo.NewGroupRepository(db),
+		Commands:     repo.NewCommandRepository(db),
+		Shadows:      repo.NewShadowRepository(db),
+		Firmware:     repo.NewFirmwareRepository(db),
+		Campaigns:    repo.NewCampaignRepository(db),
+		Blobs:        blobs,
+
+		BrokerBackend: backend,
+	}
 
-	r := http.NewRouter(cfg, deviceRepo)
+	r := http.NewRouter(cfg, repos)
 
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Starting server at %s", addr)
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TelemetryClient talks to telemetry-service-v2's internal endpoints. Calls
+// are authenticated with the shared INTERNAL_API_KEY rather than a user's
+// token, since they are made by background jobs.
+type TelemetryClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func NewTelemetryClient(baseURL, apiKey string) *TelemetryClient {
+	return &TelemetryClient{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// DeviceDeleted tells telemetry-service-v2 that deviceID is gone for good, so
+// it purges or archives the device's readings. It is safe to repeat.
+func (c *TelemetryClient) DeviceDeleted(deviceID string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/api/telemetry/internal/devices/"+url.PathEscape(deviceID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Internal-Key", c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telemetry service: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +14,22 @@ type Config struct {
 	DBPass    string
 	DBName    string
 	JWTSecret string
+
+	CommandExpiryInterval time.Duration
+
+	FirmwareDir     string
+	FirmwareMaxSize int64
+
+	TelemetryURL   string
+	TelemetryWSURL string
+	BrokerBackend  string
+	InternalAPIKey string
+
+	DeviceRestoreWindow time.Duration
+	DevicePurgeInterval time.Duration
+
+	StreamBufferSize   int
+	SlowConsumerPolicy string
 }
 
 func NewConfigFromEnv() *Config {
@@ -24,6 +42,22 @@ func NewConfigFromEnv() *Config {
 		DBPass:    getEnv("DB_PASS", "postgres"),
 		DBName:    getEnv("DB_NAME", "authdb"),
 		JWTSecret: getEnv("JWT_SECRET", "secret"),
+
+		CommandExpiryInterval: getDuration("COMMAND_EXPIRY_INTERVAL", 30*time.Second),
+
+		FirmwareDir:     getEnv("FIRMWARE_DIR", "./data/firmware"),
+		FirmwareMaxSize: getInt64("FIRMWARE_MAX_SIZE_BYTES", 64<<20),
+
+		TelemetryURL:   getEnv("TELEMETRY_SERVICE_URL", "http://telemetry-service-v2:8083"),
+		TelemetryWSURL: getEnv("TELEMETRY_SERVICE_WS_URL", "ws://telemetry-service-v2:8083/api/telemetry/ws"),
+		BrokerBackend:  getEnv("BROKER_BACKEND", "memory"),
+		InternalAPIKey: getEnv("INTERNAL_API_KEY", ""),
+
+		DeviceRestoreWindow: getDuration("DEVICE_RESTORE_WINDOW", 30*24*time.Hour),
+		DevicePurgeInterval: getDuration("DEVICE_PURGE_INTERVAL", time.Hour),
+
+		StreamBufferSize:   int(getInt64("STREAM_SUBSCRIBER_BUFFER", 64)),
+		SlowConsumerPolicy: getEnv("STREAM_SLOW_CONSUMER_POLICY", "drop_oldest"),
 	}
 }
 
@@ -33,3 +67,21 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getInt64(key string, fallback int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	CommandRunPump        = "run_pump"
+	CommandTopUpNutrients = "top_up_nutrients"
+	CommandReboot         = "reboot"
+)
+
+const (
+	CommandQueued    = "queued"
+	CommandDelivered = "delivered"
+	CommandSucceeded = "succeeded"
+	CommandFailed    = "failed"
+	CommandExpired   = "expired"
+)
+
+// commandParams lists, for every command type, the numeric parameters it
+// requires. All of them must be greater than zero.
+var commandParams = map[string][]string{
+	CommandRunPump:        {"duration_seconds"},
+	CommandTopUpNutrients: {"ml"},
+	CommandReboot:         {},
+}
+
+func CommandTypes() []string {
+	return []string{CommandRunPump, CommandTopUpNutrients, CommandReboot}
+}
+
+// ValidateCommand checks that commandType is known and that params carries
+// exactly the parameters that type needs.
+func ValidateCommand(commandType string, params map[string]interface{}) error {
+	required, ok := commandParams[commandType]
+	if !ok {
+		return fmt.Errorf("unknown command type %q", commandType)
+	}
+	for _, name := range required {
+		v, ok := params[name].(float64)
+		if !ok || v <= 0 {
+			return fmt.Errorf("%s requires a positive numeric %q", commandType, name)
+		}
+	}
+	if len(params) > len(required) {
+		return fmt.Errorf("%s accepts only %v", commandType, required)
+	}
+	return nil
+}
+
+// IsCommandOpen reports whether a command in status can still be acknowledged.
+func IsCommandOpen(status string) bool {
+	return status == CommandQueued || status == CommandDelivered
+}
+
+type Command struct {
+	ID          string                 `json:"id"`
+	DeviceID    string                 `json:"device_id"`
+	OwnerID     string                 `json:"owner_id"`
+	Type        string                 `json:"type"`
+	Params      map[string]interface{} `json:"params"`
+	Status      string                 `json:"status"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	ExpiresAt   time.Time              `json:"expires_at"`
+	CreatedAt   time.Time              `json:"created_at"`
+	DeliveredAt *time.Time             `json:"delivered_at,omitempty"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+}
+
+// CommandNotification is the payload of command_update stream events.
+type CommandNotification struct {
+	ID        string                 `json:"id"`
+	DeviceID  string                 `json:"device_id"`
+	OwnerID   string                 `json:"-"`
+	Type      string                 `json:"type"`
+	Status    string                 `json:"status"`
+	Result    map[string]interface{} `json:"result,omitempty"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
@@ -3,10 +3,21 @@ package models
 import "time"
 
 type Device struct {
-	ID         string    `json:"id"`
-	DeviceName string    `json:"device_name"`
-	OwnerID    string    `json:"owner_id"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID           string `json:"id"`
+	DeviceName   string `json:"device_name"`
+	OwnerID      string `json:"owner_id"`
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	DeviceMetadata
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// DeletedDevice is a soft-deleted device that can still be restored until
+// RestoreBefore.
+type DeletedDevice struct {
+	*Device
+	RestoreBefore time.Time `json:"restore_before"`
 }
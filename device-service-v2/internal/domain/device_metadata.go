@@ -0,0 +1,99 @@
+package models
+
+import "encoding/json"
+
+const (
+	SystemTypeNFT        = "NFT"
+	SystemTypeDWC        = "DWC"
+	SystemTypeEbbAndFlow = "ebb-and-flow"
+	SystemTypeDrip       = "drip"
+)
+
+func SystemTypes() []string {
+	return []string{SystemTypeNFT, SystemTypeDWC, SystemTypeEbbAndFlow, SystemTypeDrip}
+}
+
+func IsValidSystemType(systemType string) bool {
+	for _, t := range SystemTypes() {
+		if t == systemType {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceMetadata describes the hardware and the grow setup a device sits in.
+// It is embedded in Device, so its fields appear at the top level of the
+// device JSON.
+type DeviceMetadata struct {
+	HardwareModel         string   `json:"hardware_model,omitempty"`
+	FirmwareVersion       string   `json:"firmware_version,omitempty"`
+	SystemType            string   `json:"system_type,omitempty"`
+	LocationLabel         string   `json:"location_label,omitempty"`
+	Latitude              *float64 `json:"latitude,omitempty"`
+	Longitude             *float64 `json:"longitude,omitempty"`
+	Crop                  string   `json:"crop,omitempty"`
+	ReservoirVolumeLiters *float64 `json:"reservoir_volume_liters,omitempty"`
+	Tags                  []string `json:"tags,omitempty"`
+}
+
+// NullableFloat is a patch field that tells a missing value apart from an
+// explicit null. Set is true when the field was present; a nil Value then
+// clears the stored number.
+type NullableFloat struct {
+	Set   bool
+	Value *float64
+}
+
+func (f *NullableFloat) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	return json.Unmarshal(data, &f.Value)
+}
+
+// DeviceMetadataPatch carries the metadata fields a client wants to change.
+// Nil fields, and nullable fields that were not sent, are left untouched;
+// latitude, longitude and reservoir_volume_liters sent as null are cleared.
+type DeviceMetadataPatch struct {
+	HardwareModel         *string       `json:"hardware_model"`
+	FirmwareVersion       *string       `json:"firmware_version"`
+	SystemType            *string       `json:"system_type"`
+	LocationLabel         *string       `json:"location_label"`
+	Latitude              NullableFloat `json:"latitude"`
+	Longitude             NullableFloat `json:"longitude"`
+	Crop                  *string       `json:"crop"`
+	ReservoirVolumeLiters NullableFloat `json:"reservoir_volume_liters"`
+	Tags                  *[]string     `json:"tags"`
+}
+
+func (p *DeviceMetadataPatch) Apply(m *DeviceMetadata) {
+	if p == nil {
+		return
+	}
+	if p.HardwareModel != nil {
+		m.HardwareModel = *p.HardwareModel
+	}
+	if p.FirmwareVersion != nil {
+		m.FirmwareVersion = *p.FirmwareVersion
+	}
+	if p.SystemType != nil {
+		m.SystemType = *p.SystemType
+	}
+	if p.LocationLabel != nil {
+		m.LocationLabel = *p.LocationLabel
+	}
+	if p.Latitude.Set {
+		m.Latitude = p.Latitude.Value
+	}
+	if p.Longitude.Set {
+		m.Longitude = p.Longitude.Value
+	}
+	if p.Crop != nil {
+		m.Crop = *p.Crop
+	}
+	if p.ReservoirVolumeLiters.Set {
+		m.ReservoirVolumeLiters = p.ReservoirVolumeLiters.Value
+	}
+	if p.Tags != nil {
+		m.Tags = *p.Tags
+	}
+}
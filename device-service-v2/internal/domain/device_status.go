@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+const (
+	StatusProvisioning = "Provisioning"
+	StatusOnline       = "Online"
+	StatusOffline      = "Offline"
+	StatusMaintenance  = "Maintenance"
+	StatusError        = "Error"
+)
+
+// statusTransitions lists, for every status, the statuses a device may move
+// to next. Re-reporting the current status is always allowed.
+var statusTransitions = map[string][]string{
+	StatusProvisioning: {StatusOnline, StatusOffline, StatusMaintenance, StatusError},
+	StatusOnline:       {StatusOffline, StatusMaintenance, StatusError},
+	StatusOffline:      {StatusOnline, StatusMaintenance, StatusError, StatusProvisioning},
+	StatusMaintenance:  {StatusOnline, StatusOffline, StatusProvisioning},
+	StatusError:        {StatusOnline, StatusOffline, StatusMaintenance},
+}
+
+func Statuses() []string {
+	return []string{StatusProvisioning, StatusOnline, StatusOffline, StatusMaintenance, StatusError}
+}
+
+func IsValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether a device in status from may report status to.
+func CanTransition(from, to string) bool {
+	if !IsValidStatus(to) {
+		return false
+	}
+	if from == to {
+		return true
+	}
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusReport is what a device sends when its status changes. ErrorCode and
+// ErrorMessage are only kept while the device is in StatusError.
+type StatusReport struct {
+	Status       string
+	ErrorCode    string
+	ErrorMessage string
+}
+
+type DeviceStatusEvent struct {
+	ID           string    `json:"id"`
+	DeviceID     string    `json:"device_id"`
+	FromStatus   string    `json:"from_status"`
+	ToStatus     string    `json:"to_status"`
+	ErrorCode    string    `json:"error_code,omitempty"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DeviceStatusNotification is the payload of device_status_update stream
+// events.
+type DeviceStatusNotification struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	ErrorCode    string `json:"error_code,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	DeviceName   string `json:"device_name"`
+	DeviceMetadata
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type DeviceUptime struct {
+	DeviceID             string    `json:"device_id"`
+	From                 time.Time `json:"from"`
+	To                   time.Time `json:"to"`
+	OnlineSeconds        float64   `json:"online_seconds"`
+	UptimePercent        float64   `json:"uptime_percent"`
+	OutageCount          int       `json:"outage_count"`
+	LongestOutageSeconds float64   `json:"longest_outage_seconds"`
+}
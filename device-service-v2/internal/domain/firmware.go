@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+type Firmware struct {
+	ID            string    `json:"id"`
+	OwnerID       string    `json:"owner_id"`
+	Version       string    `json:"version"`
+	HardwareModel string    `json:"hardware_model"`
+	SHA256        string    `json:"sha256"`
+	SizeBytes     int64     `json:"size_bytes"`
+	Notes         string    `json:"notes,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const (
+	CampaignTargetAll        = "all"
+	CampaignTargetGroup      = "group"
+	CampaignTargetPercentage = "percentage"
+)
+
+const (
+	CampaignActive    = "active"
+	CampaignCompleted = "completed"
+	CampaignCancelled = "cancelled"
+)
+
+// Per-device rollout states. A device starts as pending and reports the
+// others while it downloads and installs the image.
+const (
+	RolloutPending     = "pending"
+	RolloutDownloading = "downloading"
+	RolloutInstalling  = "installing"
+	RolloutSucceeded   = "succeeded"
+	RolloutFailed      = "failed"
+)
+
+func IsRolloutFinal(status string) bool {
+	return status == RolloutSucceeded || status == RolloutFailed
+}
+
+func IsValidRolloutReport(status string) bool {
+	switch status {
+	case RolloutDownloading, RolloutInstalling, RolloutSucceeded, RolloutFailed:
+		return true
+	}
+	return false
+}
+
+type Campaign struct {
+	ID         string         `json:"id"`
+	OwnerID    string         `json:"owner_id"`
+	FirmwareID string         `json:"firmware_id"`
+	TargetType string         `json:"target_type"`
+	GroupID    string         `json:"group_id,omitempty"`
+	Percentage int            `json:"percentage,omitempty"`
+	Status     string         `json:"status"`
+	Summary    map[string]int `json:"summary,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+type CampaignDevice struct {
+	CampaignID string    `json:"campaign_id"`
+	DeviceID   string    `json:"device_id"`
+	Status     string    `json:"status"`
+	Progress   int       `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// DeviceGroup is a named set of devices, such as a grow zone or a rack. A
+// device can belong to any number of groups.
+type DeviceGroup struct {
+	ID          string    `json:"id"`
+	OwnerID     string    `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	DeviceIDs   []string  `json:"device_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Well-known shadow keys. Other keys are stored as sent.
+const (
+	ShadowTargetPpmMin          = "target_ppm_min"
+	ShadowTargetPpmMax          = "target_ppm_max"
+	ShadowPumpIntervalSeconds   = "pump_interval_seconds"
+	ShadowSamplingPeriodSeconds = "sampling_period_seconds"
+)
+
+// DeviceShadow holds the configuration a user wants a device to run with
+// (Desired) next to the configuration the device last said it runs with
+// (Reported). Each side has its own version, bumped on every change.
+type DeviceShadow struct {
+	DeviceID        string                 `json:"device_id"`
+	Desired         map[string]interface{} `json:"desired"`
+	Reported        map[string]interface{} `json:"reported"`
+	DesiredVersion  int64                  `json:"desired_version"`
+	ReportedVersion int64                  `json:"reported_version"`
+	Delta           map[string]interface{} `json:"delta"`
+	InSync          bool                   `json:"in_sync"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// Refresh recomputes Delta and InSync from Desired and Reported.
+func (s *DeviceShadow) Refresh() {
+	s.Delta = ShadowDelta(s.Desired, s.Reported)
+	s.InSync = len(s.Delta) == 0
+}
+
+// ShadowDelta returns the desired entries that the reported state does not
+// match yet. Nested objects are compared key by key.
+func ShadowDelta(desired, reported map[string]interface{}) map[string]interface{} {
+	delta := map[string]interface{}{}
+	for k, want := range desired {
+		have, ok := reported[k]
+		wantObj, wantIsObj := want.(map[string]interface{})
+		haveObj, haveIsObj := have.(map[string]interface{})
+		if ok && wantIsObj && haveIsObj {
+			if sub := ShadowDelta(wantObj, haveObj); len(sub) > 0 {
+				delta[k] = sub
+			}
+			continue
+		}
+		if !ok || !reflect.DeepEqual(want, have) {
+			delta[k] = want
+		}
+	}
+	return delta
+}
+
+// MergeShadow applies patch to doc following JSON merge patch rules: a null
+// value removes the key and nested objects are merged recursively.
+func MergeShadow(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			existing, _ := doc[k].(map[string]interface{})
+			doc[k] = MergeShadow(existing, sub)
+			continue
+		}
+		doc[k] = v
+	}
+	return doc
+}
+
+// ValidateShadow checks the well-known keys of a shadow document.
+func ValidateShadow(doc map[string]interface{}) error {
+	for _, k := range []string{ShadowTargetPpmMin, ShadowTargetPpmMax, ShadowPumpIntervalSeconds, ShadowSamplingPeriodSeconds} {
+		v, ok := doc[k]
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok || n < 0 {
+			return fmt.Errorf("%s must be a non-negative number", k)
+		}
+	}
+	min, hasMin := doc[ShadowTargetPpmMin].(float64)
+	max, hasMax := doc[ShadowTargetPpmMax].(float64)
+	if hasMin && hasMax && min > max {
+		return fmt.Errorf("%s must not be greater than %s", ShadowTargetPpmMin, ShadowTargetPpmMax)
+	}
+	return nil
+}
+
+// ShadowNotification is the payload of shadow_update stream events.
+type ShadowNotification struct {
+	DeviceID        string                 `json:"device_id"`
+	OwnerID         string                 `json:"-"`
+	DesiredVersion  int64                  `json:"desired_version"`
+	ReportedVersion int64                  `json:"reported_version"`
+	Delta           map[string]interface{} `json:"delta"`
+	InSync          bool                   `json:"in_sync"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type CommandHandler struct {
+	svc *service.CommandService
+}
+
+func NewCommandHandler(svc *service.CommandService) *CommandHandler {
+	return &CommandHandler{svc: svc}
+}
+
+func (h *CommandHandler) EnqueueCommand(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req struct {
+		Type       string                 `json:"type" binding:"required"`
+		Params     map[string]interface{} `json:"params"`
+		TTLSeconds int                    `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	command, err := h.svc.EnqueueCommand(ownerID, c.Param("id"), req.Type, req.Params, ttl)
+	if err != nil {
+		writeCommandError(c, err)
+		return
+	}
+	c.JSON(201, responseWithMessage{Success: "true", Message: "Command Queued Successfully", Devices: command})
+}
+
+func (h *CommandHandler) ListCommands(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	commands, err := h.svc.ListCommands(ownerID, c.Param("id"))
+	if err != nil {
+		writeCommandError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "commands found", Devices: commands})
+}
+
+func (h *CommandHandler) GetCommand(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	command, err := h.svc.GetCommand(ownerID, c.Param("id"), c.Param("command_id"))
+	if err != nil {
+		writeCommandError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "command found", Devices: command})
+}
+
+// Communication function with the IoT devices
+func (h *CommandHandler) FetchPendingCommands(c *gin.Context) {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(400, gin.H{"error": "device_id is required"})
+		return
+	}
+	commands, err := h.svc.FetchPendingCommands(deviceID)
+	if err != nil {
+		writeCommandError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "pending commands", Devices: commands})
+}
+
+// Communication function with the IoT devices
+func (h *CommandHandler) AcknowledgeCommand(c *gin.Context) {
+	var req struct {
+		DeviceID string                 `json:"device_id" binding:"required"`
+		Success  *bool                  `json:"success" binding:"required"`
+		Result   map[string]interface{} `json:"result"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	command, err := h.svc.AcknowledgeCommand(req.DeviceID, c.Param("command_id"), *req.Success, req.Result)
+	if err != nil {
+		writeCommandError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "command acknowledged", Devices: command})
+}
+
+func writeCommandError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidCommand):
+		c.JSON(400, gin.H{"error": err.Error(), "types": models.CommandTypes()})
+	case err == service.ErrDeviceNotFound:
+		c.JSON(404, gin.H{"error": "device not found"})
+	case err == service.ErrCommandNotFound:
+		c.JSON(404, gin.H{"error": "command not found"})
+	case err == service.ErrCommandClosed:
+		c.JSON(409, gin.H{"error": "command already completed"})
+	default:
+		c.JSON(500, gin.H{"error": "internal server error"})
+	}
+}
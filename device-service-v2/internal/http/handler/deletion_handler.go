@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type DeletionHandler struct {
+	svc *service.DeletionService
+}
+
+func NewDeletionHandler(svc *service.DeletionService) *DeletionHandler {
+	return &DeletionHandler{svc: svc}
+}
+
+func (h *DeletionHandler) DeleteDevice(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	device, err := h.svc.DeleteDevice(ownerID, c.Param("id"))
+	if err != nil {
+		writeDeletionError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "device deleted", Devices: device})
+}
+
+func (h *DeletionHandler) ListDeletedDevices(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	devices, err := h.svc.ListDeletedDevices(ownerID)
+	if err != nil {
+		writeDeletionError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "deleted devices found", Devices: devices})
+}
+
+func (h *DeletionHandler) RestoreDevice(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	device, err := h.svc.RestoreDevice(ownerID, c.Param("id"))
+	if err != nil {
+		writeDeletionError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "device restored", Devices: device})
+}
+
+func writeDeletionError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrDeviceNotFound:
+		c.JSON(404, gin.H{"error": "device not found"})
+	case service.ErrRestoreExpired:
+		c.JSON(410, gin.H{"error": "restore window has passed"})
+	default:
+		c.JSON(500, gin.H{"error": "internal server error"})
+	}
+}
@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	models "github.com/DXR3IN/device-service-v2/internal/domain"
 	"github.com/DXR3IN/device-service-v2/internal/service"
@@ -35,18 +38,29 @@ func (h *DeviceHandler) CreateDevice(c *gin.Context) {
 	var req struct {
 		DeviceID   string `json:"device_id" binding:"required"`
 		DeviceName string `json:"device_name" binding:"required"`
+		models.DeviceMetadataPatch
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	device, err := h.svc.CreateDevice(req.DeviceID, req.DeviceName, ownerID)
+	device, err := h.svc.CreateDevice(req.DeviceID, req.DeviceName, ownerID, &req.DeviceMetadataPatch)
 	if err != nil {
 		if err == service.ErrDeviceExists {
 			c.JSON(409, gin.H{"error": "device already exists"})
 			return
 		}
+		if err == service.ErrDeviceDeleted {
+			c.JSON(409, gin.H{"error": "device is deleted; restore it instead"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidMetadata) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
 	}
 
 	response := responseWithMessage{
@@ -64,45 +78,38 @@ func (h *DeviceHandler) ListDevicesByOwnerID(c *gin.Context) {
 		c.JSON(401, gin.H{"error": "unauthorized"})
 		return
 	}
-	devices, err := h.svc.GetAllDeviceWithOwnerID(ownerID)
+	devices, err := h.svc.GetAllDeviceWithOwnerID(ownerID, c.Query("group_id"))
 	if err != nil {
 		if err == service.ErrDeviceNotFound {
 			c.JSON(404, gin.H{"error": "no devices found"})
 			return
 		}
-		c.JSON(500, gin.H{"error": "internal server error"})
-		return
-	}
-	c.JSON(200, getAllDevicesResp{Devices: devices})
-}
-
-func (h *DeviceHandler) GetDeviceWithID(c *gin.Context) {
-	deviceID := c.Param("id")
-	device, err := h.svc.GetDeviceWithID(deviceID)
-	if err != nil {
-		if err == service.ErrDeviceNotFound {
-			c.JSON(404, gin.H{"error": "device not found"})
+		if err == service.ErrGroupNotFound {
+			c.JSON(404, gin.H{"error": "group not found"})
 			return
 		}
 		c.JSON(500, gin.H{"error": "internal server error"})
 		return
 	}
-	c.JSON(200, responseWithMessage{Success: "true", Message: "device found", Devices: device})
+	c.JSON(200, getAllDevicesResp{Devices: devices})
 }
 
-func (h *DeviceHandler) DeleteDevices(c *gin.Context) {
+func (h *DeviceHandler) GetDeviceWithID(c *gin.Context) {
 	ownerID := c.GetString("owner_id")
 	if ownerID == "" {
 		c.JSON(401, gin.H{"error": "unauthorized"})
 		return
 	}
-
-	err := h.svc.DeleteDevicesByOwnerID(ownerID)
+	device, err := h.svc.GetDeviceWithID(ownerID, c.Param("id"))
 	if err != nil {
+		if err == service.ErrDeviceNotFound {
+			c.JSON(404, gin.H{"error": "device not found"})
+			return
+		}
 		c.JSON(500, gin.H{"error": "internal server error"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "devices deleted"})
+	c.JSON(200, responseWithMessage{Success: "true", Message: "device found", Devices: device})
 }
 
 func (h *DeviceHandler) UpdateDeviceNameWithOwnerIDandID(c *gin.Context) {
@@ -113,17 +120,22 @@ func (h *DeviceHandler) UpdateDeviceNameWithOwnerIDandID(c *gin.Context) {
 	}
 	var req struct {
 		DeviceName string `json:"device_name" binding:"required"`
+		models.DeviceMetadataPatch
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	device, err := h.svc.UpdateDevice(ownerID, c.Param("id"), req.DeviceName)
+	device, err := h.svc.UpdateDevice(ownerID, c.Param("id"), req.DeviceName, &req.DeviceMetadataPatch)
 	if err != nil {
 		if err == service.ErrDeviceNotFound {
 			c.JSON(404, gin.H{"error": "device not found"})
 			return
 		}
+		if errors.Is(err, service.ErrInvalidMetadata) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(500, gin.H{"error": "internal server error"})
 		return
 	}
@@ -135,19 +147,111 @@ func (h *DeviceHandler) UpdateDeviceNameWithOwnerIDandID(c *gin.Context) {
 	c.JSON(200, response)
 }
 
+func (h *DeviceHandler) GetStatusHistory(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	events, err := h.svc.GetStatusHistory(ownerID, c.Param("id"), from, to)
+	if err != nil {
+		switch err {
+		case service.ErrDeviceNotFound:
+			c.JSON(404, gin.H{"error": "device not found"})
+		case service.ErrInvalidTimeRange:
+			c.JSON(400, gin.H{"error": "from must be before to"})
+		default:
+			c.JSON(500, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "status history found", Devices: events})
+}
+
+func (h *DeviceHandler) GetUptime(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	uptime, err := h.svc.GetUptime(ownerID, c.Param("id"), from, to)
+	if err != nil {
+		switch err {
+		case service.ErrDeviceNotFound:
+			c.JSON(404, gin.H{"error": "device not found"})
+		case service.ErrInvalidTimeRange:
+			c.JSON(400, gin.H{"error": "from must be before to"})
+		default:
+			c.JSON(500, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "uptime computed", Devices: uptime})
+}
+
+// parseTimeRange reads the RFC 3339 `from`/`to` query parameters. `to`
+// defaults to now and `from` to 24 hours before `to`.
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid to, expected RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid from, expected RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+	return from, to, true
+}
+
 // Communication function with the IoT devices
 func (h *DeviceHandler) UpdateDeviceStatusByID(c *gin.Context) {
 	var req struct {
-		ID     string `json:"id"`
-		Status string `json:"status"`
+		ID           string `json:"id" binding:"required"`
+		Status       string `json:"status" binding:"required"`
+		ErrorCode    string `json:"error_code"`
+		ErrorMessage string `json:"error_message"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	data, err := h.svc.UpdateDeviceStatusByID(req.ID, req.Status)
+	report := &models.StatusReport{
+		Status:       req.Status,
+		ErrorCode:    req.ErrorCode,
+		ErrorMessage: req.ErrorMessage,
+	}
+	data, err := h.svc.UpdateDeviceStatusByID(req.ID, report)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "internal server error"})
+		switch err {
+		case service.ErrDeviceNotFound:
+			c.JSON(404, gin.H{"error": "device not found"})
+		case service.ErrInvalidStatus:
+			c.JSON(422, gin.H{"error": "unknown status", "allowed": models.Statuses()})
+		case service.ErrInvalidTransition:
+			c.JSON(422, gin.H{"error": "status transition not allowed"})
+		case service.ErrStatusConflict:
+			c.JSON(409, gin.H{"error": "device status changed, retry"})
+		default:
+			c.JSON(500, gin.H{"error": "internal server error"})
+		}
 		return
 	}
 	response := responseWithMessage{
@@ -160,22 +264,75 @@ func (h *DeviceHandler) UpdateDeviceStatusByID(c *gin.Context) {
 
 // SSE stream event handler
 func (h *DeviceHandler) StreamDeviceStatus(c *gin.Context) {
-	clientChan := make(chan interface{})
-	h.svc.Broker.NewClients <- clientChan
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// Optional group_id and device_id filters limit the stream to those
+	// devices. Group membership is resolved when the client connects.
+	var deviceIDs []string
+	for _, v := range c.QueryArray("device_id") {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				deviceIDs = append(deviceIDs, id)
+			}
+		}
+	}
+	filter, err := h.svc.StreamFilter(ownerID, c.Query("group_id"), deviceIDs)
+	if err != nil {
+		switch err {
+		case service.ErrGroupNotFound:
+			c.JSON(404, gin.H{"error": "group not found"})
+		case service.ErrDeviceNotFound:
+			c.JSON(404, gin.H{"error": "device not found"})
+		default:
+			c.JSON(500, gin.H{"error": "internal server error"})
+		}
+		return
+	}
 
-	defer func() {
-		h.svc.Broker.ClosingClients <- clientChan
-	}()
+	sub, missed, resumed := h.svc.Broker.Subscribe(ownerID, filter, lastEventID(c))
+	defer h.svc.Broker.Unsubscribe(sub)
 
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+	// A client that resumes from a known event only needs what it missed;
+	// everyone else starts from a snapshot of the current state. It is read
+	// before the stream starts so that a failure can still answer 500.
+	var snapshot []*models.DeviceStatusNotification
+	if !resumed {
+		if snapshot, err = h.svc.StreamSnapshot(ownerID, filter); err != nil {
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+	}
+
+	setSSEHeaders(c)
+
+	if !resumed {
+		writeSSE(c, 0, "snapshot", snapshot)
+	}
+	for _, ev := range missed {
+		writeSSE(c, ev.ID, ev.Name, ev.Data)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 
 	for {
 		select {
-		case data := <-clientChan:
-			c.SSEvent("device_status_update", data)
+		case ev := <-sub.Events:
+			writeSSE(c, ev.ID, ev.Name, ev.Data)
+			c.Writer.Flush()
+
+		case <-sub.Closed:
+			// Too slow for the disconnect policy; the client resumes from
+			// its Last-Event-ID.
+			return
+
+		case <-heartbeat.C:
+			writeHeartbeat(c)
 			c.Writer.Flush()
 
 		case <-c.Request.Context().Done():
@@ -183,3 +340,26 @@ func (h *DeviceHandler) StreamDeviceStatus(c *gin.Context) {
 		}
 	}
 }
+
+// ListSubscriptions shows every live stream subscription with its queue
+// depth and drop counter.
+func (h *DeviceHandler) ListSubscriptions(c *gin.Context) {
+	subs := h.svc.Broker.Subscriptions()
+	c.JSON(200, responseWithMessage{Success: "true", Message: "subscriptions found", Devices: subs})
+}
+
+// GetDeviceOwner is called by telemetry-service-v2 to check who may read a
+// device's telemetry.
+func (h *DeviceHandler) GetDeviceOwner(c *gin.Context) {
+	deviceID := c.Param("id")
+	ownerID, err := h.svc.DeviceOwner(deviceID)
+	if err != nil {
+		if err == service.ErrDeviceNotFound {
+			c.JSON(404, gin.H{"error": "device not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "device owner found", Devices: gin.H{"device_id": deviceID, "owner_id": ownerID}})
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is how much an upload may exceed the image size limit
+// to make room for the other form fields and the multipart framing.
+const multipartOverhead = 1 << 20
+
+type FirmwareHandler struct {
+	svc *service.FirmwareService
+}
+
+func NewFirmwareHandler(svc *service.FirmwareService) *FirmwareHandler {
+	return &FirmwareHandler{svc: svc}
+}
+
+// UploadFirmware expects a multipart form with the image in the "file" field.
+func (h *FirmwareHandler) UploadFirmware(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	// Cap the body before gin parses the form, which spills everything past
+	// its memory limit into temp files.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.svc.MaxSize()+multipartOverhead)
+	if _, err := c.MultipartForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(413, gin.H{"error": "firmware image too large"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	version := c.PostForm("version")
+	hardwareModel := c.PostForm("hardware_model")
+	if version == "" || hardwareModel == "" {
+		c.JSON(400, gin.H{"error": "version and hardware_model are required"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "file is required"})
+		return
+	}
+	image, err := file.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer image.Close()
+
+	firmware, err := h.svc.UploadFirmware(ownerID, version, hardwareModel, c.PostForm("sha256"), c.PostForm("notes"), image)
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(201, responseWithMessage{Success: "true", Message: "Firmware Uploaded Successfully", Devices: firmware})
+}
+
+func (h *FirmwareHandler) ListFirmware(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	firmware, err := h.svc.ListFirmware(ownerID)
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "firmware found", Devices: firmware})
+}
+
+func (h *FirmwareHandler) GetFirmware(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	firmware, err := h.svc.GetFirmware(ownerID, c.Param("id"))
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "firmware found", Devices: firmware})
+}
+
+func (h *FirmwareHandler) CreateCampaign(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req struct {
+		FirmwareID string `json:"firmware_id" binding:"required"`
+		Target     string `json:"target" binding:"required"`
+		GroupID    string `json:"group_id"`
+		Percentage int    `json:"percentage"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	campaign, err := h.svc.CreateCampaign(ownerID, req.FirmwareID, req.Target, req.GroupID, req.Percentage)
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(201, responseWithMessage{Success: "true", Message: "Campaign Created Successfully", Devices: campaign})
+}
+
+func (h *FirmwareHandler) ListCampaigns(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	campaigns, err := h.svc.ListCampaigns(ownerID)
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "campaigns found", Devices: campaigns})
+}
+
+func (h *FirmwareHandler) GetCampaign(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	campaign, devices, err := h.svc.GetCampaign(ownerID, c.Param("id"))
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "campaign found", Devices: gin.H{
+		"campaign": campaign,
+		"devices":  devices,
+	}})
+}
+
+func (h *FirmwareHandler) CancelCampaign(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	campaign, err := h.svc.CancelCampaign(ownerID, c.Param("id"))
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "campaign cancelled", Devices: campaign})
+}
+
+// Communication function with the IoT devices
+func (h *FirmwareHandler) CheckForUpdate(c *gin.Context) {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(400, gin.H{"error": "device_id is required"})
+		return
+	}
+	update, err := h.svc.CheckForUpdate(deviceID)
+	if err == service.ErrNoUpdateAvailable {
+		c.JSON(200, gin.H{"update_available": false})
+		return
+	}
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"update_available": true,
+		"campaign_id":      update.CampaignID,
+		"firmware_id":      update.Firmware.ID,
+		"version":          update.Firmware.Version,
+		"sha256":           update.Firmware.SHA256,
+		"size_bytes":       update.Firmware.SizeBytes,
+		"download_url":     fmt.Sprintf("/api/device/iot/firmware/%s/download?device_id=%s", update.Firmware.ID, deviceID),
+	})
+}
+
+// Communication function with the IoT devices
+func (h *FirmwareHandler) DownloadFirmware(c *gin.Context) {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(400, gin.H{"error": "device_id is required"})
+		return
+	}
+	firmware, image, err := h.svc.OpenImage(deviceID, c.Param("firmware_id"))
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	defer image.Close()
+
+	c.DataFromReader(200, firmware.SizeBytes, "application/octet-stream", image, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s-%s.bin"`, firmware.HardwareModel, firmware.Version),
+		"X-Firmware-SHA256":   firmware.SHA256,
+	})
+}
+
+// Communication function with the IoT devices
+func (h *FirmwareHandler) ReportProgress(c *gin.Context) {
+	var req struct {
+		DeviceID   string `json:"device_id" binding:"required"`
+		CampaignID string `json:"campaign_id" binding:"required"`
+		Status     string `json:"status" binding:"required"`
+		Progress   int    `json:"progress"`
+		Error      string `json:"error"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	row, err := h.svc.ReportProgress(req.DeviceID, req.CampaignID, req.Status, req.Progress, req.Error)
+	if err != nil {
+		writeFirmwareError(c, err)
+		return
+	}
+	c.JSON(200, row)
+}
+
+func writeFirmwareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidCampaign), errors.Is(err, service.ErrInvalidRollout):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case err == service.ErrChecksumMismatch:
+		c.JSON(400, gin.H{"error": "sha256 does not match the uploaded file"})
+	case err == service.ErrFirmwareTooLarge:
+		c.JSON(413, gin.H{"error": "firmware image too large"})
+	case err == service.ErrFirmwareNotFound:
+		c.JSON(404, gin.H{"error": "firmware not found"})
+	case err == service.ErrCampaignNotFound:
+		c.JSON(404, gin.H{"error": "campaign not found"})
+	case err == service.ErrGroupNotFound:
+		c.JSON(404, gin.H{"error": "group not found"})
+	case err == service.ErrDeviceNotFound:
+		c.JSON(404, gin.H{"error": "device not found"})
+	case err == service.ErrRolloutClosed:
+		c.JSON(409, gin.H{"error": "campaign is not active or the device already reported a final status"})
+	case err == service.ErrFirmwareExists:
+		c.JSON(409, gin.H{"error": "firmware version already exists for this hardware model"})
+	default:
+		c.JSON(500, gin.H{"error": "internal server error"})
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type GroupHandler struct {
+	svc *service.GroupService
+}
+
+func NewGroupHandler(svc *service.GroupService) *GroupHandler {
+	return &GroupHandler{svc: svc}
+}
+
+type groupReq struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description"`
+}
+
+func (h *GroupHandler) CreateGroup(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req groupReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	group, err := h.svc.CreateGroup(ownerID, req.Name, req.Description)
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(201, responseWithMessage{Success: "true", Message: "Group Created Successfully", Devices: group})
+}
+
+func (h *GroupHandler) ListGroups(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	groups, err := h.svc.ListGroups(ownerID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "groups found", Devices: groups})
+}
+
+func (h *GroupHandler) GetGroup(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	group, err := h.svc.GetGroup(ownerID, c.Param("id"))
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "group found", Devices: group})
+}
+
+func (h *GroupHandler) UpdateGroup(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req groupReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	group, err := h.svc.UpdateGroup(ownerID, c.Param("id"), req.Name, req.Description)
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "Group Updated Successfully", Devices: group})
+}
+
+func (h *GroupHandler) DeleteGroup(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err := h.svc.DeleteGroup(ownerID, c.Param("id")); err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "group deleted"})
+}
+
+func (h *GroupHandler) AddDevices(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req struct {
+		DeviceIDs []string `json:"device_ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	group, err := h.svc.AddDevices(ownerID, c.Param("id"), req.DeviceIDs)
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "devices added to group", Devices: group})
+}
+
+func (h *GroupHandler) RemoveDevice(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	group, err := h.svc.RemoveDevice(ownerID, c.Param("id"), c.Param("device_id"))
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "device removed from group", Devices: group})
+}
+
+func writeGroupError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrGroupNotFound:
+		c.JSON(404, gin.H{"error": "group not found"})
+	case service.ErrDeviceNotFound:
+		c.JSON(404, gin.H{"error": "device not found"})
+	case service.ErrGroupExists:
+		c.JSON(409, gin.H{"error": "group already exists"})
+	default:
+		c.JSON(500, gin.H{"error": "internal server error"})
+	}
+}
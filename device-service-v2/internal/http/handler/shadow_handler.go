@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type ShadowHandler struct {
+	svc *service.ShadowService
+}
+
+func NewShadowHandler(svc *service.ShadowService) *ShadowHandler {
+	return &ShadowHandler{svc: svc}
+}
+
+func (h *ShadowHandler) GetShadow(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	shadow, err := h.svc.GetShadow(ownerID, c.Param("id"))
+	if err != nil {
+		writeShadowError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "shadow found", Devices: shadow})
+}
+
+func (h *ShadowHandler) UpdateDesired(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req struct {
+		Desired map[string]interface{} `json:"desired" binding:"required"`
+		Version *int64                 `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	shadow, err := h.svc.UpdateDesired(ownerID, c.Param("id"), req.Desired, req.Version)
+	if err != nil {
+		writeShadowError(c, err)
+		return
+	}
+	c.JSON(200, responseWithMessage{Success: "true", Message: "desired state updated", Devices: shadow})
+}
+
+// Communication function with the IoT devices
+func (h *ShadowHandler) GetDelta(c *gin.Context) {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(400, gin.H{"error": "device_id is required"})
+		return
+	}
+	shadow, err := h.svc.GetDelta(deviceID)
+	if err != nil {
+		writeShadowError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"version": shadow.DesiredVersion, "delta": shadow.Delta})
+}
+
+// Communication function with the IoT devices
+func (h *ShadowHandler) ReportState(c *gin.Context) {
+	var req struct {
+		DeviceID string                 `json:"device_id" binding:"required"`
+		Reported map[string]interface{} `json:"reported" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	shadow, err := h.svc.ReportState(req.DeviceID, req.Reported)
+	if err != nil {
+		writeShadowError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"version": shadow.DesiredVersion, "delta": shadow.Delta})
+}
+
+func writeShadowError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidShadow):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case err == service.ErrDeviceNotFound:
+		c.JSON(404, gin.H{"error": "device not found"})
+	case err == service.ErrShadowConflict:
+		c.JSON(409, gin.H{"error": "shadow was changed, reload and retry"})
+	default:
+		c.JSON(500, gin.H{"error": "internal server error"})
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// heartbeatInterval keeps idle streams below the read timeout of proxies
+// such as nginx (60s by default).
+const heartbeatInterval = 15 * time.Second
+
+func setSSEHeaders(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+}
+
+// writeSSE sends one event. An id of 0 leaves out the id field, so the
+// client keeps its last event ID.
+func writeSSE(c *gin.Context, id uint64, event string, data interface{}) {
+	ev := sse.Event{Event: event, Data: data}
+	if id != 0 {
+		ev.Id = strconv.FormatUint(id, 10)
+	}
+	c.Render(-1, ev)
+}
+
+func writeHeartbeat(c *gin.Context) {
+	c.Writer.WriteString(": heartbeat\n\n")
+}
+
+// lastEventID reads the ID a reconnecting client resumes from. Browsers send
+// the Last-Event-ID header; last_event_id is accepted for clients that cannot
+// set headers.
+func lastEventID(c *gin.Context) uint64 {
+	v := c.GetHeader("Last-Event-ID")
+	if v == "" {
+		v = c.Query("last_event_id")
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
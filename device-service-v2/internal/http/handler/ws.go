@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket message types. Clients send subscribe, unsubscribe, command and
+// ping; the server answers with ack, error, event and pong.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsCommand     = "command"
+	wsPing        = "ping"
+	wsAck         = "ack"
+	wsError       = "error"
+	wsEvent       = "event"
+	wsPong        = "pong"
+)
+
+// WebSocket topics. telemetry is relayed from telemetry-service-v2.
+const (
+	topicDeviceStatus = "device_status"
+	topicTelemetry    = "telemetry"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsMaxMessage   = 64 << 10
+	wsSendBuffer   = 64
+)
+
+// wsMessage is the JSON envelope used on the WebSocket in both directions.
+// The same envelope is used by telemetry-service-v2.
+type wsMessage struct {
+	Type      string         `json:"type"`
+	RequestID string         `json:"request_id,omitempty"`
+	Topic     string         `json:"topic,omitempty"`
+	DeviceIDs []string       `json:"device_ids,omitempty"`
+	GroupID   string         `json:"group_id,omitempty"`
+	DeviceID  string         `json:"device_id,omitempty"`
+	Command   *wsCommandBody `json:"command,omitempty"`
+	Event     string         `json:"event,omitempty"`
+	ID        uint64         `json:"id,omitempty"`
+	Data      interface{}    `json:"data,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type wsCommandBody struct {
+	Type       string                 `json:"type"`
+	Params     map[string]interface{} `json:"params"`
+	TTLSeconds int                    `json:"ttl_seconds"`
+}
+
+// Authentication happens before the upgrade through a bearer token or a
+// stream ticket, never through cookies, so cross-origin pages are allowed.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsSession owns one client connection. Only writeLoop writes data frames;
+// everyone else hands messages to send.
+type wsSession struct {
+	conn *websocket.Conn
+	out  chan interface{}
+	done chan struct{}
+}
+
+func newWSSession(conn *websocket.Conn) *wsSession {
+	s := &wsSession{
+		conn: conn,
+		out:  make(chan interface{}, wsSendBuffer),
+		done: make(chan struct{}),
+	}
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+	})
+	go s.writeLoop()
+	return s
+}
+
+// send queues msg for the client. It returns false once the session is
+// closed.
+func (s *wsSession) send(msg interface{}) bool {
+	select {
+	case s.out <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *wsSession) ack(requestID string, data interface{}) {
+	s.send(&wsMessage{Type: wsAck, RequestID: requestID, Data: data})
+}
+
+func (s *wsSession) fail(requestID, msg string) {
+	s.send(&wsMessage{Type: wsError, RequestID: requestID, Error: msg})
+}
+
+// close ends the session. It must be called once, by the read loop.
+func (s *wsSession) close() {
+	close(s.done)
+	s.conn.Close()
+}
+
+func (s *wsSession) writeLoop() {
+	ping := time.NewTicker(heartbeatInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-s.out:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				// Closing the connection makes the read loop exit and clean up.
+				s.conn.Close()
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				s.conn.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
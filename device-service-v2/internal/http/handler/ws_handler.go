@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WSHandler is the WebSocket gateway for control panels. It serves the
+// device_status topic from the local broker, accepts commands, and relays
+// the telemetry topic to telemetry-service-v2 over a second WebSocket.
+type WSHandler struct {
+	devices      *service.DeviceService
+	commands     *service.CommandService
+	telemetryURL string
+	internalKey  string
+}
+
+func NewWSHandler(devices *service.DeviceService, commands *service.CommandService, telemetryURL, internalKey string) *WSHandler {
+	return &WSHandler{devices: devices, commands: commands, telemetryURL: telemetryURL, internalKey: internalKey}
+}
+
+func (h *WSHandler) ServeWebSocket(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	relay := h.newTelemetryRelay(ownerID)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		return
+	}
+	s := newWSSession(conn)
+	defer s.close()
+	defer relay.close()
+
+	var statusStop chan struct{}
+	defer func() {
+		if statusStop != nil {
+			close(statusStop)
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.fail("", "invalid message")
+			continue
+		}
+
+		switch {
+		case msg.Type == wsPing:
+			s.send(&wsMessage{Type: wsPong, RequestID: msg.RequestID})
+
+		case msg.Type == wsSubscribe && msg.Topic == topicDeviceStatus:
+			filter, err := h.devices.StreamFilter(ownerID, msg.GroupID, msg.DeviceIDs)
+			if err != nil {
+				s.fail(msg.RequestID, wsErrorText(err))
+				continue
+			}
+			// A new subscription replaces the previous filter.
+			if statusStop != nil {
+				close(statusStop)
+			}
+			statusStop = make(chan struct{})
+			h.subscribeStatus(s, ownerID, filter, statusStop)
+			s.ack(msg.RequestID, gin.H{"topic": topicDeviceStatus})
+
+		case msg.Type == wsUnsubscribe && msg.Topic == topicDeviceStatus:
+			if statusStop != nil {
+				close(statusStop)
+				statusStop = nil
+			}
+			s.ack(msg.RequestID, gin.H{"topic": topicDeviceStatus})
+
+		case msg.Type == wsSubscribe && msg.Topic == topicTelemetry:
+			if len(msg.DeviceIDs) == 0 {
+				s.fail(msg.RequestID, "device_ids is required")
+				continue
+			}
+			// telemetry-service-v2 checks ownership as well; checking here
+			// gives the client the same errors as device_status.
+			if _, err := h.devices.StreamFilter(ownerID, "", msg.DeviceIDs); err != nil {
+				s.fail(msg.RequestID, wsErrorText(err))
+				continue
+			}
+			if err := relay.forward(s, data); err != nil {
+				s.fail(msg.RequestID, "telemetry service unavailable")
+			}
+
+		case msg.Type == wsUnsubscribe && msg.Topic == topicTelemetry:
+			if err := relay.forward(s, data); err != nil {
+				s.fail(msg.RequestID, "telemetry service unavailable")
+			}
+
+		case msg.Type == wsSubscribe, msg.Type == wsUnsubscribe:
+			s.fail(msg.RequestID, "unknown topic")
+
+		case msg.Type == wsCommand:
+			if msg.Command == nil || msg.DeviceID == "" {
+				s.fail(msg.RequestID, "device_id and command are required")
+				continue
+			}
+			ttl := time.Duration(msg.Command.TTLSeconds) * time.Second
+			command, err := h.commands.EnqueueCommand(ownerID, msg.DeviceID, msg.Command.Type, msg.Command.Params, ttl)
+			if err != nil {
+				s.fail(msg.RequestID, wsErrorText(err))
+				continue
+			}
+			s.ack(msg.RequestID, command)
+
+		default:
+			s.fail(msg.RequestID, "unknown message type")
+		}
+	}
+}
+
+// subscribeStatus registers with the broker before returning, so the ack
+// that follows means no event is missed, and pumps events until stop.
+func (h *WSHandler) subscribeStatus(s *wsSession, ownerID string, filter map[string]struct{}, stop chan struct{}) {
+	sub, _, _ := h.devices.Broker.Subscribe(ownerID, filter, 0)
+
+	go func() {
+		defer h.devices.Broker.Unsubscribe(sub)
+		for {
+			select {
+			case ev := <-sub.Events:
+				if !s.send(&wsMessage{
+					Type:     wsEvent,
+					Topic:    topicDeviceStatus,
+					Event:    ev.Name,
+					DeviceID: ev.DeviceID,
+					ID:       ev.ID,
+					Data:     ev.Data,
+				}) {
+					return
+				}
+			case <-sub.Closed:
+				// Too slow for the disconnect policy: drop the whole socket.
+				s.conn.Close()
+				return
+			case <-stop:
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// telemetryRelay is the gateway's connection to telemetry-service-v2. It is
+// dialed on the first telemetry subscription, and redialed after a loss, as
+// the caller's owner with the shared internal key: the caller's own token or
+// stream ticket may have expired by then. Whatever telemetry-service-v2
+// sends is passed to the client unchanged.
+type telemetryRelay struct {
+	url    string
+	header http.Header
+	conn   *websocket.Conn
+}
+
+func (h *WSHandler) newTelemetryRelay(ownerID string) *telemetryRelay {
+	header := http.Header{}
+	header.Set("X-Internal-Key", h.internalKey)
+	header.Set("X-Owner-ID", ownerID)
+	return &telemetryRelay{url: h.telemetryURL, header: header}
+}
+
+var relayDialer = websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+
+// forward sends a client message upstream, redialing once if the previous
+// connection was lost.
+func (r *telemetryRelay) forward(s *wsSession, data []byte) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if r.conn == nil {
+			conn, _, err := relayDialer.Dial(r.url, r.header)
+			if err != nil {
+				return err
+			}
+			r.conn = conn
+			go r.pump(s, conn)
+		}
+		r.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		err := r.conn.WriteMessage(websocket.TextMessage, data)
+		if err == nil {
+			return nil
+		}
+		r.conn.Close()
+		r.conn = nil
+	}
+	return errors.New("telemetry relay write failed")
+}
+
+func (r *telemetryRelay) pump(s *wsSession, conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			s.send(&wsMessage{Type: wsError, Topic: topicTelemetry, Error: "telemetry stream closed, subscribe again"})
+			return
+		}
+		if !s.send(json.RawMessage(data)) {
+			return
+		}
+	}
+}
+
+func (r *telemetryRelay) close() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+func wsErrorText(err error) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidCommand):
+		return err.Error()
+	case err == service.ErrDeviceNotFound:
+		return "device not found"
+	case err == service.ErrGroupNotFound:
+		return "group not found"
+	default:
+		return "internal server error"
+	}
+}
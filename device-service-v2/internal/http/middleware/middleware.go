@@ -33,6 +33,28 @@ func DeviceRequired(jwtMgr *utils.JWTManager) gin.HandlerFunc {
 	}
 }
 
+// StreamRequired authenticates event streams. Browsers pass a stream ticket
+// from auth-service as ?ticket=, since EventSource cannot set headers; other
+// clients may keep using the Authorization header.
+func StreamRequired(jwtMgr *utils.JWTManager) gin.HandlerFunc {
+	bearer := DeviceRequired(jwtMgr)
+
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			bearer(c)
+			return
+		}
+		claims, err := jwtMgr.VerifyStreamTicket(ticket)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid stream ticket"})
+			return
+		}
+		c.Set("owner_id", claims.Subject)
+		c.Next()
+	}
+}
+
 func IoTRequired() gin.HandlerFunc {
 	requiredKey := os.Getenv("IOT_API_KEY")
 
@@ -47,3 +69,39 @@ func IoTRequired() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// InternalRequired guards endpoints that only other services call. They
+// send the shared INTERNAL_API_KEY as X-Internal-Key; without it configured
+// every call is refused.
+func InternalRequired() gin.HandlerFunc {
+	requiredKey := os.Getenv("INTERNAL_API_KEY")
+
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-Internal-Key")
+
+		if requiredKey == "" || apiKey != requiredKey {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DebugRequired guards debug endpoints with the DEBUG_API_KEY sent as
+// X-Debug-Key. Without DEBUG_API_KEY the endpoints do not exist.
+func DebugRequired() gin.HandlerFunc {
+	requiredKey := os.Getenv("DEBUG_API_KEY")
+
+	return func(c *gin.Context) {
+		if requiredKey == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if c.GetHeader("X-Debug-Key") != requiredKey {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
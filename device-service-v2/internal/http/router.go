@@ -1,37 +1,130 @@
 package http
 
 import (
+	"github.com/DXR3IN/device-service-v2/internal/client"
 	"github.com/DXR3IN/device-service-v2/internal/config"
 	h "github.com/DXR3IN/device-service-v2/internal/http/handler"
 	"github.com/DXR3IN/device-service-v2/internal/http/middleware"
 	"github.com/DXR3IN/device-service-v2/internal/repository"
 	"github.com/DXR3IN/device-service-v2/internal/service"
+	"github.com/DXR3IN/device-service-v2/internal/storage"
 	"github.com/DXR3IN/device-service-v2/internal/utils"
 	ginpkg "github.com/gin-gonic/gin"
 )
 
-func NewRouter(cfg *config.Config, deviceRepo repository.DeviceRepository) *ginpkg.Engine {
+// Repositories bundles the data access the router wires into the services.
+type Repositories struct {
+	Devices      repository.DeviceRepository
+	StatusEvents repository.StatusEventRepository
+	Groups       repository.GroupRepository
+	Commands     repository.CommandRepository
+	Shadows      repository.ShadowRepository
+	Firmware     repository.FirmwareRepository
+	Campaigns    repository.CampaignRepository
+	Blobs        storage.BlobStore
+
+	// BrokerBackend shares stream events between instances; nil keeps
+	// them in process.
+	BrokerBackend service.BrokerBackend
+}
+
+func NewRouter(cfg *config.Config, repos Repositories) *ginpkg.Engine {
 	r := ginpkg.Default()
 
 	jwtMgr := utils.NewJWTManagerFromEnv()
 
 	// Device routes
-	deviceSvc := service.NewDeviceService(deviceRepo, jwtMgr)
+	deviceSvc := service.NewDeviceService(repos.Devices, repos.StatusEvents, repos.Groups, jwtMgr, service.BrokerOptions{
+		Backend:    repos.BrokerBackend,
+		BufferSize: cfg.StreamBufferSize,
+		Policy:     service.SlowConsumerPolicy(cfg.SlowConsumerPolicy),
+	})
+	broker := deviceSvc.Broker
 	deviceHandler := h.NewDeviceHandler(deviceSvc)
 
+	commandSvc := service.NewCommandService(repos.Commands, repos.Devices, broker)
+	commandHandler := h.NewCommandHandler(commandSvc)
+	go commandSvc.RunExpiry(cfg.CommandExpiryInterval)
+
+	shadowSvc := service.NewShadowService(repos.Shadows, repos.Devices, broker)
+	shadowHandler := h.NewShadowHandler(shadowSvc)
+
+	telemetryClient := client.NewTelemetryClient(cfg.TelemetryURL, cfg.InternalAPIKey)
+	deletionSvc := service.NewDeletionService(repos.Devices, telemetryClient, cfg.DeviceRestoreWindow)
+	deletionHandler := h.NewDeletionHandler(deletionSvc)
+	go deletionSvc.RunPurge(cfg.DevicePurgeInterval)
+
+	wsHandler := h.NewWSHandler(deviceSvc, commandSvc, cfg.TelemetryWSURL, cfg.InternalAPIKey)
+	r.GET("/api/ws", middleware.StreamRequired(jwtMgr), wsHandler.ServeWebSocket)
+
+	r.GET("/api/devices/stream", middleware.StreamRequired(jwtMgr), deviceHandler.StreamDeviceStatus)
+
 	device := r.Group("/api/devices")
 	device.Use(middleware.DeviceRequired(jwtMgr))
 	device.POST("/", deviceHandler.CreateDevice)
 	device.GET("/", deviceHandler.ListDevicesByOwnerID)
+	device.GET("/deleted", deletionHandler.ListDeletedDevices)
 	device.GET("/:id", deviceHandler.GetDeviceWithID)
 	device.PUT("/:id", deviceHandler.UpdateDeviceNameWithOwnerIDandID)
-	device.DELETE("/:id", deviceHandler.DeleteDevices)
-	device.GET("/stream", deviceHandler.StreamDeviceStatus)
+	device.DELETE("/:id", deletionHandler.DeleteDevice)
+	device.POST("/:id/restore", deletionHandler.RestoreDevice)
+	device.GET("/:id/status-history", deviceHandler.GetStatusHistory)
+	device.GET("/:id/uptime", deviceHandler.GetUptime)
+	device.POST("/:id/commands", commandHandler.EnqueueCommand)
+	device.GET("/:id/commands", commandHandler.ListCommands)
+	device.GET("/:id/commands/:command_id", commandHandler.GetCommand)
+	device.GET("/:id/shadow", shadowHandler.GetShadow)
+	device.PATCH("/:id/shadow/desired", shadowHandler.UpdateDesired)
+
+	// Group routes
+	groupSvc := service.NewGroupService(repos.Groups, repos.Devices)
+	groupHandler := h.NewGroupHandler(groupSvc)
+
+	group := r.Group("/api/groups")
+	group.Use(middleware.DeviceRequired(jwtMgr))
+	group.POST("/", groupHandler.CreateGroup)
+	group.GET("/", groupHandler.ListGroups)
+	group.GET("/:id", groupHandler.GetGroup)
+	group.PUT("/:id", groupHandler.UpdateGroup)
+	group.DELETE("/:id", groupHandler.DeleteGroup)
+	group.POST("/:id/devices", groupHandler.AddDevices)
+	group.DELETE("/:id/devices/:device_id", groupHandler.RemoveDevice)
+
+	// Firmware routes
+	firmwareSvc := service.NewFirmwareService(repos.Firmware, repos.Campaigns, repos.Devices, repos.Groups, repos.Blobs, cfg.FirmwareMaxSize)
+	firmwareHandler := h.NewFirmwareHandler(firmwareSvc)
+
+	firmware := r.Group("/api/firmware")
+	firmware.Use(middleware.DeviceRequired(jwtMgr))
+	firmware.POST("/", firmwareHandler.UploadFirmware)
+	firmware.GET("/", firmwareHandler.ListFirmware)
+	firmware.POST("/campaigns", firmwareHandler.CreateCampaign)
+	firmware.GET("/campaigns", firmwareHandler.ListCampaigns)
+	firmware.GET("/campaigns/:id", firmwareHandler.GetCampaign)
+	firmware.POST("/campaigns/:id/cancel", firmwareHandler.CancelCampaign)
+	firmware.GET("/:id", firmwareHandler.GetFirmware)
+
+	// Service to service
+	internal := r.Group("/api/internal")
+	internal.Use(middleware.InternalRequired())
+	internal.GET("/devices/:id/owner", deviceHandler.GetDeviceOwner)
+
+	// Debug routes
+	debug := r.Group("/api/debug")
+	debug.Use(middleware.DebugRequired())
+	debug.GET("/subscriptions", deviceHandler.ListSubscriptions)
 
 	// IoT into the devices
 	iotToDevice := r.Group("/api/device/iot")
 	iotToDevice.Use(middleware.IoTRequired())
 	iotToDevice.POST("/status", deviceHandler.UpdateDeviceStatusByID)
+	iotToDevice.GET("/commands", commandHandler.FetchPendingCommands)
+	iotToDevice.POST("/commands/:command_id/ack", commandHandler.AcknowledgeCommand)
+	iotToDevice.GET("/shadow/delta", shadowHandler.GetDelta)
+	iotToDevice.POST("/shadow/reported", shadowHandler.ReportState)
+	iotToDevice.GET("/firmware/check", firmwareHandler.CheckForUpdate)
+	iotToDevice.GET("/firmware/:firmware_id/download", firmwareHandler.DownloadFirmware)
+	iotToDevice.POST("/firmware/progress", firmwareHandler.ReportProgress)
 
 	return r
 }
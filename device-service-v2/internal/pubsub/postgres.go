@@ -0,0 +1,129 @@
+// Package pubsub carries broker events between service instances.
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// maxPayload is Postgres' NOTIFY payload limit (8000 bytes) minus room for
+// the event ID prefix.
+const maxPayload = 7900
+
+// Message is one published event as received by every instance. IDs come
+// from a Postgres sequence, so they are the same on every instance. A
+// Message without Payload carries no event: it follows a reconnect and holds
+// the latest ID handed out so far, so that receivers can tell that the
+// events in between were missed.
+type Message struct {
+	ID      uint64
+	Payload []byte
+}
+
+// Postgres fans events out with LISTEN/NOTIFY. Publishing goes through the
+// gorm pool; receiving uses a dedicated connection that reconnects on error.
+type Postgres struct {
+	db       *gorm.DB
+	dsn      string
+	channel  string
+	sequence string
+	messages chan Message
+}
+
+// NewPostgres listens on channel and creates the sequence that numbers its
+// events. channel must be a plain SQL identifier.
+func NewPostgres(db *gorm.DB, dsn, channel string) (*Postgres, error) {
+	p := &Postgres{
+		db:       db,
+		dsn:      dsn,
+		channel:  channel,
+		sequence: channel + "_ids",
+		messages: make(chan Message, 256),
+	}
+	if err := db.Exec("CREATE SEQUENCE IF NOT EXISTS " + p.sequence).Error; err != nil {
+		return nil, err
+	}
+	conn, err := p.listen(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	go p.receive(conn)
+	return p, nil
+}
+
+// Publish numbers payload and notifies every listening instance, this one
+// included. The ID is taken and the notification queued in one transaction
+// under a lock, so every instance receives the events in ID order.
+func (p *Postgres) Publish(payload []byte) error {
+	if len(payload) > maxPayload {
+		return fmt.Errorf("event of %d bytes exceeds the NOTIFY limit", len(payload))
+	}
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", p.channel).Error; err != nil {
+			return err
+		}
+		return tx.Exec("SELECT pg_notify(?, nextval(?)::text || ' ' || ?)", p.channel, p.sequence, string(payload)).Error
+	})
+}
+
+func (p *Postgres) Messages() <-chan Message {
+	return p.messages
+}
+
+func (p *Postgres) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+p.channel); err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *Postgres) receive(conn *pgx.Conn) {
+	ctx := context.Background()
+	backoff := time.Second
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			// Events published while disconnected are lost. Once listening
+			// again, the latest ID tells receivers how far they fell behind.
+			log.Printf("pubsub: listen on %s failed: %v", p.channel, err)
+			conn.Close(ctx)
+			for {
+				time.Sleep(backoff)
+				if conn, err = p.listen(ctx); err == nil {
+					backoff = time.Second
+					break
+				}
+				if backoff < 30*time.Second {
+					backoff *= 2
+				}
+			}
+			var last int64
+			if err := conn.QueryRow(ctx, "SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM "+p.sequence).Scan(&last); err != nil {
+				log.Printf("pubsub: reading %s failed: %v", p.sequence, err)
+			} else {
+				p.messages <- Message{ID: uint64(last)}
+			}
+			continue
+		}
+
+		idStr, payload, ok := strings.Cut(n.Payload, " ")
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if !ok || err != nil {
+			log.Printf("pubsub: malformed notification on %s", p.channel)
+			continue
+		}
+		p.messages <- Message{ID: id, Payload: []byte(payload)}
+	}
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+)
+
+type Campaign struct {
+	ID         string `gorm:"primaryKey;type:varchar(36);not null"`
+	OwnerID    string `gorm:"type:uuid;not null;index"`
+	FirmwareID string `gorm:"type:varchar(36);not null"`
+	TargetType string `gorm:"type:varchar(16);not null"`
+	GroupID    string `gorm:"type:varchar(36)"`
+	Percentage int
+	Status     string `gorm:"type:varchar(16);not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type CampaignDevice struct {
+	CampaignID string `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID   string `gorm:"primaryKey;type:varchar(36);not null;index"`
+	Status     string `gorm:"type:varchar(16);not null"`
+	Progress   int    `gorm:"not null;default:0"`
+	Error      string `gorm:"type:text"`
+	UpdatedAt  time.Time
+}
+
+func (c *Campaign) ToDomain() *models.Campaign {
+	if c == nil {
+		return nil
+	}
+	return &models.Campaign{
+		ID:         c.ID,
+		OwnerID:    c.OwnerID,
+		FirmwareID: c.FirmwareID,
+		TargetType: c.TargetType,
+		GroupID:    c.GroupID,
+		Percentage: c.Percentage,
+		Status:     c.Status,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+func (d *CampaignDevice) ToDomain() *models.CampaignDevice {
+	if d == nil {
+		return nil
+	}
+	return &models.CampaignDevice{
+		CampaignID: d.CampaignID,
+		DeviceID:   d.DeviceID,
+		Status:     d.Status,
+		Progress:   d.Progress,
+		Error:      d.Error,
+		UpdatedAt:  d.UpdatedAt,
+	}
+}
+
+type CampaignRepository interface {
+	Create(c *Campaign, deviceIDs []string) error
+	FindByID(id string) (*models.Campaign, error)
+	FindByOwnerID(ownerID string) ([]*models.Campaign, error)
+	FindDevices(campaignID string) ([]*models.CampaignDevice, error)
+	Summarize(campaignID string) (map[string]int, error)
+	FindOpenForDevice(deviceID string) (*models.Campaign, *models.CampaignDevice, error)
+	UpdateDeviceProgress(campaignID, deviceID, status string, progress int, errMsg string) (*models.CampaignDevice, bool, error)
+	SetStatus(campaignID, status string) error
+	CompleteIfFinished(campaignID string) error
+}
+
+type campaignRepo struct {
+	db *gorm.DB
+}
+
+func NewCampaignRepository(db *gorm.DB) CampaignRepository {
+	return &campaignRepo{db: db}
+}
+
+// Create stores the campaign together with one pending rollout row for every
+// targeted device.
+func (r *campaignRepo) Create(c *Campaign, deviceIDs []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(c).Error; err != nil {
+			return err
+		}
+		if len(deviceIDs) == 0 {
+			return nil
+		}
+		rows := make([]CampaignDevice, 0, len(deviceIDs))
+		for _, id := range deviceIDs {
+			rows = append(rows, CampaignDevice{CampaignID: c.ID, DeviceID: id, Status: models.RolloutPending})
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+func (r *campaignRepo) FindByID(id string) (*models.Campaign, error) {
+	var campaign Campaign
+	if err := r.db.First(&campaign, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return campaign.ToDomain(), nil
+}
+
+func (r *campaignRepo) FindByOwnerID(ownerID string) ([]*models.Campaign, error) {
+	var campaigns []Campaign
+	if err := r.db.Where("owner_id = ?", ownerID).Order("created_at DESC").Find(&campaigns).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.Campaign, 0, len(campaigns))
+	for i := range campaigns {
+		result = append(result, campaigns[i].ToDomain())
+	}
+	return result, nil
+}
+
+func (r *campaignRepo) FindDevices(campaignID string) ([]*models.CampaignDevice, error) {
+	var rows []CampaignDevice
+	if err := r.db.Where("campaign_id = ?", campaignID).Order("device_id ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.CampaignDevice, 0, len(rows))
+	for i := range rows {
+		result = append(result, rows[i].ToDomain())
+	}
+	return result, nil
+}
+
+func (r *campaignRepo) Summarize(campaignID string) (map[string]int, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	if err := r.db.Model(&CampaignDevice{}).
+		Select("status, COUNT(*) AS count").
+		Where("campaign_id = ?", campaignID).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	summary := map[string]int{}
+	for _, row := range rows {
+		summary[row.Status] = row.Count
+	}
+	return summary, nil
+}
+
+// FindOpenForDevice returns the newest active campaign in which the device
+// has not finished its update yet.
+func (r *campaignRepo) FindOpenForDevice(deviceID string) (*models.Campaign, *models.CampaignDevice, error) {
+	var row CampaignDevice
+	err := r.db.Model(&CampaignDevice{}).
+		Joins("JOIN campaigns ON campaigns.id = campaign_devices.campaign_id").
+		Where("campaign_devices.device_id = ? AND campaigns.status = ? AND campaign_devices.status NOT IN ?",
+			deviceID, models.CampaignActive, []string{models.RolloutSucceeded, models.RolloutFailed}).
+		Order("campaigns.created_at DESC").
+		First(&row).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	campaign, err := r.FindByID(row.CampaignID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return campaign, row.ToDomain(), nil
+}
+
+// UpdateDeviceProgress records a report while the campaign is active and
+// the device has not reached a final status. It returns the device's row,
+// nil if the device is not part of the campaign, and whether it changed.
+func (r *campaignRepo) UpdateDeviceProgress(campaignID, deviceID, status string, progress int, errMsg string) (*models.CampaignDevice, bool, error) {
+	res := r.db.Model(&CampaignDevice{}).
+		Where("campaign_id = ? AND device_id = ? AND status NOT IN ?",
+			campaignID, deviceID, []string{models.RolloutSucceeded, models.RolloutFailed}).
+		Where("campaign_id IN (SELECT id FROM campaigns WHERE status = ?)", models.CampaignActive).
+		Updates(map[string]interface{}{"status": status, "progress": progress, "error": errMsg, "updated_at": time.Now()})
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	var row CampaignDevice
+	if err := r.db.First(&row, "campaign_id = ? AND device_id = ?", campaignID, deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return row.ToDomain(), res.RowsAffected > 0, nil
+}
+
+func (r *campaignRepo) SetStatus(campaignID, status string) error {
+	return r.db.Model(&Campaign{}).Where("id = ?", campaignID).
+		Updates(map[string]interface{}{"status": status, "updated_at": time.Now()}).Error
+}
+
+// CompleteIfFinished marks an active campaign completed once none of its
+// devices is still pending or in progress.
+func (r *campaignRepo) CompleteIfFinished(campaignID string) error {
+	open := r.db.Model(&CampaignDevice{}).Select("1").
+		Where("campaign_id = ? AND status NOT IN ?", campaignID, []string{models.RolloutSucceeded, models.RolloutFailed})
+	return r.db.Model(&Campaign{}).
+		Where("id = ? AND status = ? AND NOT EXISTS (?)", campaignID, models.CampaignActive, open).
+		Updates(map[string]interface{}{"status": models.CampaignCompleted, "updated_at": time.Now()}).Error
+}
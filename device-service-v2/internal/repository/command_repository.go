@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCommandClosed is returned when a command already reached a final state.
+var ErrCommandClosed = errors.New("command already completed")
+
+type Command struct {
+	ID          string                 `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID    string                 `gorm:"type:varchar(36);not null;index:idx_commands_device_status,priority:1"`
+	OwnerID     string                 `gorm:"type:uuid;not null"`
+	Type        string                 `gorm:"type:varchar(32);not null"`
+	Params      map[string]interface{} `gorm:"serializer:json;type:jsonb"`
+	Status      string                 `gorm:"type:varchar(16);not null;index:idx_commands_device_status,priority:2"`
+	Result      map[string]interface{} `gorm:"serializer:json;type:jsonb"`
+	ExpiresAt   time.Time              `gorm:"not null;index"`
+	CreatedAt   time.Time
+	DeliveredAt *time.Time
+	CompletedAt *time.Time
+}
+
+func (c *Command) ToDomain() *models.Command {
+	if c == nil {
+		return nil
+	}
+	return &models.Command{
+		ID:          c.ID,
+		DeviceID:    c.DeviceID,
+		OwnerID:     c.OwnerID,
+		Type:        c.Type,
+		Params:      c.Params,
+		Status:      c.Status,
+		Result:      c.Result,
+		ExpiresAt:   c.ExpiresAt,
+		CreatedAt:   c.CreatedAt,
+		DeliveredAt: c.DeliveredAt,
+		CompletedAt: c.CompletedAt,
+	}
+}
+
+type CommandRepository interface {
+	Create(c *Command) error
+	FindByID(id string) (*models.Command, error)
+	FindByDeviceID(deviceID string, limit int) ([]*models.Command, error)
+	DeliverPending(deviceID string, now time.Time) ([]*models.Command, error)
+	Complete(id, deviceID, status string, result map[string]interface{}, now time.Time) (*models.Command, error)
+	ExpireDue(now time.Time) ([]*models.Command, error)
+}
+
+type commandRepo struct {
+	db *gorm.DB
+}
+
+func NewCommandRepository(db *gorm.DB) CommandRepository {
+	return &commandRepo{db: db}
+}
+
+func (r *commandRepo) Create(c *Command) error {
+	return r.db.Create(c).Error
+}
+
+func (r *commandRepo) FindByID(id string) (*models.Command, error) {
+	var command Command
+	if err := r.db.First(&command, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return command.ToDomain(), nil
+}
+
+func (r *commandRepo) FindByDeviceID(deviceID string, limit int) ([]*models.Command, error) {
+	var commands []Command
+	if err := r.db.Where("device_id = ?", deviceID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&commands).Error; err != nil {
+		return nil, err
+	}
+	return toDomainCommands(commands), nil
+}
+
+// DeliverPending marks the device's queued, unexpired commands as delivered
+// and returns them oldest first. A command is handed out at most once.
+func (r *commandRepo) DeliverPending(deviceID string, now time.Time) ([]*models.Command, error) {
+	var commands []Command
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("device_id = ? AND status = ? AND expires_at > ?", deviceID, models.CommandQueued, now).
+			Order("created_at ASC").
+			Find(&commands).Error; err != nil {
+			return err
+		}
+		if len(commands) == 0 {
+			return nil
+		}
+		ids := make([]string, 0, len(commands))
+		for i := range commands {
+			ids = append(ids, commands[i].ID)
+			commands[i].Status = models.CommandDelivered
+			commands[i].DeliveredAt = &now
+		}
+		return tx.Model(&Command{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"status": models.CommandDelivered, "delivered_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toDomainCommands(commands), nil
+}
+
+// Complete moves an open command of deviceID to a final status.
+func (r *commandRepo) Complete(id, deviceID, status string, result map[string]interface{}, now time.Time) (*models.Command, error) {
+	var command Command
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&command, "id = ? AND device_id = ?", id, deviceID).Error; err != nil {
+			return err
+		}
+		if !models.IsCommandOpen(command.Status) {
+			return ErrCommandClosed
+		}
+		command.Status = status
+		command.Result = result
+		command.CompletedAt = &now
+		return tx.Save(&command).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return command.ToDomain(), nil
+}
+
+// ExpireDue marks every open command whose TTL ran out as expired and returns
+// the affected commands.
+func (r *commandRepo) ExpireDue(now time.Time) ([]*models.Command, error) {
+	var commands []Command
+	if err := r.db.Model(&commands).
+		Clauses(clause.Returning{}).
+		Where("status IN ? AND expires_at <= ?", []string{models.CommandQueued, models.CommandDelivered}, now).
+		Updates(map[string]interface{}{"status": models.CommandExpired, "completed_at": now}).Error; err != nil {
+		return nil, err
+	}
+	return toDomainCommands(commands), nil
+}
+
+func toDomainCommands(commands []Command) []*models.Command {
+	result := make([]*models.Command, 0, len(commands))
+	for i := range commands {
+		result = append(result, commands[i].ToDomain())
+	}
+	return result
+}
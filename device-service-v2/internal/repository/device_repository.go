@@ -5,16 +5,70 @@ import (
 	"time"
 
 	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrStatusChanged is returned when the device status moved on between the
+// caller reading it and trying to update it.
+var ErrStatusChanged = errors.New("device status changed concurrently")
+
+// ErrNotDeleted is returned when a device to purge was restored or purged in
+// the meantime.
+var ErrNotDeleted = errors.New("device is not deleted")
+
 type Device struct {
-	ID         string `gorm:"primaryKey;type:varchar(36);not null"`
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
-	DeviceName string `gorm:"not null"`
-	OwnerID    string `gorm:"type:uuid;not null"`
-	Status     string `gorm:"type:varchar(20);not null;default:'Offline'"`
+	ID           string `gorm:"primaryKey;type:varchar(36);not null"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeviceName   string         `gorm:"not null"`
+	OwnerID      string         `gorm:"type:uuid;not null"`
+	Status       string         `gorm:"type:varchar(20);not null;default:'Provisioning';check:chk_devices_status,status IN ('Provisioning','Online','Offline','Maintenance','Error')"`
+	ErrorCode    string         `gorm:"type:varchar(64)"`
+	ErrorMessage string         `gorm:"type:text"`
+	Metadata     DeviceMetadata `gorm:"embedded"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+}
+
+type DeviceMetadata struct {
+	HardwareModel         string `gorm:"type:varchar(64)"`
+	FirmwareVersion       string `gorm:"type:varchar(32)"`
+	SystemType            string `gorm:"type:varchar(20)"`
+	LocationLabel         string `gorm:"type:varchar(255)"`
+	Latitude              *float64
+	Longitude             *float64
+	Crop                  string `gorm:"type:varchar(100)"`
+	ReservoirVolumeLiters *float64
+	Tags                  []string `gorm:"serializer:json;type:jsonb"`
+}
+
+func (m DeviceMetadata) ToDomain() models.DeviceMetadata {
+	return models.DeviceMetadata{
+		HardwareModel:         m.HardwareModel,
+		FirmwareVersion:       m.FirmwareVersion,
+		SystemType:            m.SystemType,
+		LocationLabel:         m.LocationLabel,
+		Latitude:              m.Latitude,
+		Longitude:             m.Longitude,
+		Crop:                  m.Crop,
+		ReservoirVolumeLiters: m.ReservoirVolumeLiters,
+		Tags:                  m.Tags,
+	}
+}
+
+func ToRepositoryMetadata(m models.DeviceMetadata) DeviceMetadata {
+	return DeviceMetadata{
+		HardwareModel:         m.HardwareModel,
+		FirmwareVersion:       m.FirmwareVersion,
+		SystemType:            m.SystemType,
+		LocationLabel:         m.LocationLabel,
+		Latitude:              m.Latitude,
+		Longitude:             m.Longitude,
+		Crop:                  m.Crop,
+		ReservoirVolumeLiters: m.ReservoirVolumeLiters,
+		Tags:                  m.Tags,
+	}
 }
 
 func (d *Device) ToDomain() *models.Device {
@@ -23,22 +77,51 @@ func (d *Device) ToDomain() *models.Device {
 	}
 
 	return &models.Device{
-		ID:         d.ID,
-		DeviceName: d.DeviceName,
-		OwnerID:    d.OwnerID,
-		CreatedAt:  d.CreatedAt,
-		UpdatedAt:  d.UpdatedAt,
-		Status:     d.Status,
+		ID:             d.ID,
+		DeviceName:     d.DeviceName,
+		OwnerID:        d.OwnerID,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+		Status:         d.Status,
+		ErrorCode:      d.ErrorCode,
+		ErrorMessage:   d.ErrorMessage,
+		DeviceMetadata: d.Metadata.ToDomain(),
+		DeletedAt:      deletedAt(d.DeletedAt),
+	}
+}
+
+func deletedAt(d gorm.DeletedAt) *time.Time {
+	if !d.Valid {
+		return nil
+	}
+	t := d.Time
+	return &t
+}
+
+// MigrateDeviceStatuses moves devices stored before statuses were validated
+// to Offline, so that AutoMigrate can add the status CHECK constraint.
+func MigrateDeviceStatuses(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Device{}) {
+		return nil
 	}
+	return db.Unscoped().Model(&Device{}).
+		Where("status NOT IN ?", models.Statuses()).
+		UpdateColumn("status", models.StatusOffline).Error
 }
 
 type DeviceRepository interface {
 	Create(d *Device) error
 	FindByID(id string) (*models.Device, error)
 	FindByOwnerID(ownerID string) ([]*models.Device, error)
-	DeleteByOwnerID(ownerID string) error
-	UpdateDevice(ownerID, deviceID, deviceName string) (*models.Device, error)
-	UpdateStatusByID(deviceID, status string) (*models.Device, error)
+	SoftDelete(ownerID, deviceID string) (*models.Device, error)
+	FindDeletedByID(id string) (*models.Device, error)
+	FindDeletedByOwnerID(ownerID string) ([]*models.Device, error)
+	FindDeletedBefore(cutoff time.Time) ([]*models.Device, error)
+	Restore(deviceID string, deletedAfter time.Time) (*models.Device, error)
+	Purge(deviceID string) error
+	UpdateDevice(ownerID, deviceID, deviceName string, metadata models.DeviceMetadata, setFirmware bool) (*models.Device, error)
+	UpdateStatusByID(deviceID, expected string, report *models.StatusReport) (*models.Device, error)
+	UpdateFirmwareVersion(deviceID, version string) error
 }
 
 type deviceRepo struct {
@@ -76,37 +159,177 @@ func (r *deviceRepo) FindByOwnerID(ownerID string) ([]*models.Device, error) {
 	return result, nil
 }
 
-func (r *deviceRepo) DeleteByOwnerID(ownerID string) error {
-	return r.db.Where("owner_id = ?", ownerID).Delete(&Device{}).Error
-}
-
-func (r *deviceRepo) UpdateDevice(ownerID, deviceID, deviceName string) (*models.Device, error) {
+// SoftDelete marks the owner's device as deleted. Deleted devices disappear
+// from every other query until they are restored or purged.
+func (r *deviceRepo) SoftDelete(ownerID, deviceID string) (*models.Device, error) {
 	var device Device
-	if err := r.db.First(&device, "id = ? AND owner_id =?", deviceID, ownerID).Error; err != nil {
+	if err := r.db.First(&device, "id = ? AND owner_id = ?", deviceID, ownerID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	device.DeviceName = deviceName
-	device.UpdatedAt = time.Time{}
-	if err := r.db.Save(&device).Error; err != nil {
+	if err := r.db.Delete(&device).Error; err != nil {
 		return nil, err
 	}
-	return device.ToDomain(), nil
+	return r.FindDeletedByID(deviceID)
 }
 
-func (r *deviceRepo) UpdateStatusByID(deviceID, status string) (*models.Device, error) {
+func (r *deviceRepo) FindDeletedByID(id string) (*models.Device, error) {
 	var device Device
-	if err := r.db.First(&device, "id = ?", deviceID).Error; err != nil {
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&device, "id = ?", id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	device.Status = status
-	if err := r.db.First(&device, "id = ?", deviceID).Error; err != nil {
+	return device.ToDomain(), nil
+}
+
+func (r *deviceRepo) FindDeletedByOwnerID(ownerID string) ([]*models.Device, error) {
+	var devices []Device
+	if err := r.db.Unscoped().
+		Where("owner_id = ? AND deleted_at IS NOT NULL", ownerID).
+		Order("deleted_at DESC").
+		Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.Device, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, d.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *deviceRepo) FindDeletedBefore(cutoff time.Time) ([]*models.Device, error) {
+	var devices []Device
+	if err := r.db.Unscoped().Where("deleted_at < ?", cutoff).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.Device, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, d.ToDomain())
+	}
+	return result, nil
+}
+
+// Restore undeletes a device deleted after deletedAfter. A device deleted
+// earlier is due for purging and stays deleted, even if the purge job has
+// already started on it.
+func (r *deviceRepo) Restore(deviceID string, deletedAfter time.Time) (*models.Device, error) {
+	res := r.db.Unscoped().Model(&Device{}).
+		Where("id = ? AND deleted_at IS NOT NULL AND deleted_at >= ?", deviceID, deletedAfter).
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.FindByID(deviceID)
+}
+
+// Purge removes a deleted device for good, together with everything stored
+// about it in this service. The device row is locked first, so a concurrent
+// restore either happens before, and the purge aborts with ErrNotDeleted, or
+// waits for it.
+func (r *deviceRepo) Purge(deviceID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&device, "id = ? AND deleted_at IS NOT NULL", deviceID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotDeleted
+		}
+		if err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&DeviceGroupMember{},
+			&DeviceStatusEvent{},
+			&Command{},
+			&DeviceShadow{},
+			&CampaignDevice{},
+		} {
+			if err := tx.Where("device_id = ?", deviceID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id = ?", deviceID).Delete(&Device{}).Error
+	})
+}
+
+// deviceMetadataColumns are the metadata columns UpdateDevice writes.
+// firmware_version is left out because OTA reports set it as well.
+var deviceMetadataColumns = []string{
+	"hardware_model", "system_type", "location_label", "latitude", "longitude",
+	"crop", "reservoir_volume_liters", "tags",
+}
+
+// UpdateDevice writes the name and metadata of a live device, plus its
+// firmware version when setFirmware is true. Status, error and the deletion
+// state are left alone, so a concurrent status report or delete is not
+// overwritten.
+func (r *deviceRepo) UpdateDevice(ownerID, deviceID, deviceName string, metadata models.DeviceMetadata, setFirmware bool) (*models.Device, error) {
+	columns := append([]string{"device_name", "updated_at"}, deviceMetadataColumns...)
+	if setFirmware {
+		columns = append(columns, "firmware_version")
+	}
+	result := r.db.Model(&Device{}).
+		Where("id = ? AND owner_id = ?", deviceID, ownerID).
+		Select(columns).
+		Updates(&Device{DeviceName: deviceName, Metadata: ToRepositoryMetadata(metadata)})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.FindByID(deviceID)
+}
+
+// UpdateStatusByID applies report as long as the stored status still equals
+// expected, and records the transition in device_status_events whenever the
+// status or the reported error code changes.
+func (r *deviceRepo) UpdateStatusByID(deviceID, expected string, report *models.StatusReport) (*models.Device, error) {
+	var device Device
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, "id = ?", deviceID).Error; err != nil {
+			return err
+		}
+		if device.Status != expected {
+			return ErrStatusChanged
+		}
+		previous, previousCode := device.Status, device.ErrorCode
+		device.Status = report.Status
+		device.ErrorCode = report.ErrorCode
+		device.ErrorMessage = report.ErrorMessage
+		if err := tx.Save(&device).Error; err != nil {
+			return err
+		}
+		if previous == report.Status && previousCode == report.ErrorCode {
+			return nil
+		}
+		event := &DeviceStatusEvent{
+			ID:           uuid.New().String(),
+			DeviceID:     deviceID,
+			FromStatus:   previous,
+			ToStatus:     report.Status,
+			ErrorCode:    report.ErrorCode,
+			ErrorMessage: report.ErrorMessage,
+			CreatedAt:    device.UpdatedAt,
+		}
+		return tx.Create(event).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return device.ToDomain(), nil
 }
+
+func (r *deviceRepo) UpdateFirmwareVersion(deviceID, version string) error {
+	return r.db.Model(&Device{}).Where("id = ?", deviceID).Update("firmware_version", version).Error
+}
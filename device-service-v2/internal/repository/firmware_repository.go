@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+)
+
+type Firmware struct {
+	ID            string `gorm:"primaryKey;type:varchar(36);not null"`
+	OwnerID       string `gorm:"type:uuid;not null;uniqueIndex:idx_firmware_owner_model_version,priority:1"`
+	Version       string `gorm:"type:varchar(32);not null;uniqueIndex:idx_firmware_owner_model_version,priority:3"`
+	HardwareModel string `gorm:"type:varchar(64);not null;uniqueIndex:idx_firmware_owner_model_version,priority:2"`
+	SHA256        string `gorm:"type:char(64);not null"`
+	SizeBytes     int64  `gorm:"not null"`
+	Notes         string `gorm:"type:text"`
+	CreatedAt     time.Time
+}
+
+func (f *Firmware) ToDomain() *models.Firmware {
+	if f == nil {
+		return nil
+	}
+	return &models.Firmware{
+		ID:            f.ID,
+		OwnerID:       f.OwnerID,
+		Version:       f.Version,
+		HardwareModel: f.HardwareModel,
+		SHA256:        f.SHA256,
+		SizeBytes:     f.SizeBytes,
+		Notes:         f.Notes,
+		CreatedAt:     f.CreatedAt,
+	}
+}
+
+type FirmwareRepository interface {
+	Create(f *Firmware) error
+	FindByID(id string) (*models.Firmware, error)
+	FindByOwnerID(ownerID string) ([]*models.Firmware, error)
+	FindByVersion(ownerID, hardwareModel, version string) (*models.Firmware, error)
+}
+
+type firmwareRepo struct {
+	db *gorm.DB
+}
+
+func NewFirmwareRepository(db *gorm.DB) FirmwareRepository {
+	return &firmwareRepo{db: db}
+}
+
+func (r *firmwareRepo) Create(f *Firmware) error {
+	return r.db.Create(f).Error
+}
+
+func (r *firmwareRepo) FindByID(id string) (*models.Firmware, error) {
+	var firmware Firmware
+	if err := r.db.First(&firmware, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return firmware.ToDomain(), nil
+}
+
+func (r *firmwareRepo) FindByOwnerID(ownerID string) ([]*models.Firmware, error) {
+	var firmware []Firmware
+	if err := r.db.Where("owner_id = ?", ownerID).Order("created_at DESC").Find(&firmware).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.Firmware, 0, len(firmware))
+	for i := range firmware {
+		result = append(result, firmware[i].ToDomain())
+	}
+	return result, nil
+}
+
+func (r *firmwareRepo) FindByVersion(ownerID, hardwareModel, version string) (*models.Firmware, error) {
+	var firmware Firmware
+	if err := r.db.First(&firmware, "owner_id = ? AND hardware_model = ? AND version = ?", ownerID, hardwareModel, version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return firmware.ToDomain(), nil
+}
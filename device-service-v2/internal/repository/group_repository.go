@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DeviceGroup struct {
+	ID          string `gorm:"primaryKey;type:varchar(36);not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	OwnerID     string `gorm:"type:uuid;not null;uniqueIndex:idx_device_groups_owner_name,priority:1"`
+	Name        string `gorm:"type:varchar(100);not null;uniqueIndex:idx_device_groups_owner_name,priority:2"`
+	Description string `gorm:"type:text"`
+}
+
+type DeviceGroupMember struct {
+	GroupID   string `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID  string `gorm:"primaryKey;type:varchar(36);not null;index"`
+	CreatedAt time.Time
+}
+
+func (g *DeviceGroup) ToDomain(deviceIDs []string) *models.DeviceGroup {
+	if g == nil {
+		return nil
+	}
+	if deviceIDs == nil {
+		deviceIDs = []string{}
+	}
+	return &models.DeviceGroup{
+		ID:          g.ID,
+		OwnerID:     g.OwnerID,
+		Name:        g.Name,
+		Description: g.Description,
+		DeviceIDs:   deviceIDs,
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.UpdatedAt,
+	}
+}
+
+type GroupRepository interface {
+	Create(g *DeviceGroup) error
+	FindByID(id string) (*models.DeviceGroup, error)
+	FindByOwnerID(ownerID string) ([]*models.DeviceGroup, error)
+	Update(id, name, description string) (*models.DeviceGroup, error)
+	Delete(id string) error
+	AddDevices(groupID string, deviceIDs []string) error
+	RemoveDevice(groupID, deviceID string) error
+	FindGroupIDsByDeviceID(deviceID string) ([]string, error)
+}
+
+type groupRepo struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return &groupRepo{db: db}
+}
+
+func (r *groupRepo) Create(g *DeviceGroup) error {
+	return r.db.Create(g).Error
+}
+
+func (r *groupRepo) FindByID(id string) (*models.DeviceGroup, error) {
+	var group DeviceGroup
+	if err := r.db.First(&group, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	members, err := r.memberIDs([]string{group.ID})
+	if err != nil {
+		return nil, err
+	}
+	return group.ToDomain(members[group.ID]), nil
+}
+
+func (r *groupRepo) FindByOwnerID(ownerID string) ([]*models.DeviceGroup, error) {
+	var groups []DeviceGroup
+	if err := r.db.Where("owner_id = ?", ownerID).Order("name ASC").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	members, err := r.memberIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*models.DeviceGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g.ToDomain(members[g.ID]))
+	}
+	return result, nil
+}
+
+func (r *groupRepo) Update(id, name, description string) (*models.DeviceGroup, error) {
+	res := r.db.Model(&DeviceGroup{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"name": name, "description": description, "updated_at": time.Now()})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.FindByID(id)
+}
+
+func (r *groupRepo) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&DeviceGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&DeviceGroup{}).Error
+	})
+}
+
+func (r *groupRepo) AddDevices(groupID string, deviceIDs []string) error {
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+	members := make([]DeviceGroupMember, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		members = append(members, DeviceGroupMember{GroupID: groupID, DeviceID: id})
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+func (r *groupRepo) RemoveDevice(groupID, deviceID string) error {
+	return r.db.Where("group_id = ? AND device_id = ?", groupID, deviceID).Delete(&DeviceGroupMember{}).Error
+}
+
+func (r *groupRepo) FindGroupIDsByDeviceID(deviceID string) ([]string, error) {
+	var ids []string
+	if err := r.db.Model(&DeviceGroupMember{}).Where("device_id = ?", deviceID).Pluck("group_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *groupRepo) memberIDs(groupIDs []string) (map[string][]string, error) {
+	result := make(map[string][]string, len(groupIDs))
+	if len(groupIDs) == 0 {
+		return result, nil
+	}
+	var members []DeviceGroupMember
+	// Deleted devices keep their memberships so a restore brings them back,
+	// but they are hidden until then.
+	live := r.db.Model(&Device{}).Select("id")
+	if err := r.db.Where("group_id IN ? AND device_id IN (?)", groupIDs, live).Order("created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		result[m.GroupID] = append(result[m.GroupID], m.DeviceID)
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrShadowVersionConflict is returned when a shadow section was changed
+// since the version the caller based its update on.
+var ErrShadowVersionConflict = errors.New("shadow version conflict")
+
+type DeviceShadow struct {
+	DeviceID        string                 `gorm:"primaryKey;type:varchar(36);not null"`
+	Desired         map[string]interface{} `gorm:"serializer:json;type:jsonb"`
+	Reported        map[string]interface{} `gorm:"serializer:json;type:jsonb"`
+	DesiredVersion  int64                  `gorm:"not null;default:0"`
+	ReportedVersion int64                  `gorm:"not null;default:0"`
+	UpdatedAt       time.Time
+}
+
+func (s *DeviceShadow) ToDomain() *models.DeviceShadow {
+	if s == nil {
+		return nil
+	}
+	shadow := &models.DeviceShadow{
+		DeviceID:        s.DeviceID,
+		Desired:         s.Desired,
+		Reported:        s.Reported,
+		DesiredVersion:  s.DesiredVersion,
+		ReportedVersion: s.ReportedVersion,
+		UpdatedAt:       s.UpdatedAt,
+	}
+	if shadow.Desired == nil {
+		shadow.Desired = map[string]interface{}{}
+	}
+	if shadow.Reported == nil {
+		shadow.Reported = map[string]interface{}{}
+	}
+	shadow.Refresh()
+	return shadow
+}
+
+type ShadowRepository interface {
+	FindByDeviceID(deviceID string) (*models.DeviceShadow, error)
+	SaveDesired(deviceID string, expectedVersion int64, desired map[string]interface{}) (*models.DeviceShadow, error)
+	SaveReported(deviceID string, expectedVersion int64, reported map[string]interface{}) (*models.DeviceShadow, error)
+}
+
+type shadowRepo struct {
+	db *gorm.DB
+}
+
+func NewShadowRepository(db *gorm.DB) ShadowRepository {
+	return &shadowRepo{db: db}
+}
+
+// FindByDeviceID returns an empty shadow at version 0 when the device has
+// none stored yet.
+func (r *shadowRepo) FindByDeviceID(deviceID string) (*models.DeviceShadow, error) {
+	var shadow DeviceShadow
+	if err := r.db.First(&shadow, "device_id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return (&DeviceShadow{DeviceID: deviceID}).ToDomain(), nil
+		}
+		return nil, err
+	}
+	return shadow.ToDomain(), nil
+}
+
+func (r *shadowRepo) SaveDesired(deviceID string, expectedVersion int64, desired map[string]interface{}) (*models.DeviceShadow, error) {
+	return r.save(deviceID, func(s *DeviceShadow) error {
+		if s.DesiredVersion != expectedVersion {
+			return ErrShadowVersionConflict
+		}
+		s.Desired = desired
+		s.DesiredVersion++
+		return nil
+	})
+}
+
+func (r *shadowRepo) SaveReported(deviceID string, expectedVersion int64, reported map[string]interface{}) (*models.DeviceShadow, error) {
+	return r.save(deviceID, func(s *DeviceShadow) error {
+		if s.ReportedVersion != expectedVersion {
+			return ErrShadowVersionConflict
+		}
+		s.Reported = reported
+		s.ReportedVersion++
+		return nil
+	})
+}
+
+func (r *shadowRepo) save(deviceID string, apply func(*DeviceShadow) error) (*models.DeviceShadow, error) {
+	var shadow DeviceShadow
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists first, so that the lock below serializes
+		// concurrent writers even on a device's first write.
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&DeviceShadow{DeviceID: deviceID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&shadow, "device_id = ?", deviceID).Error; err != nil {
+			return err
+		}
+		if err := apply(&shadow); err != nil {
+			return err
+		}
+		return tx.Save(&shadow).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shadow.ToDomain(), nil
+}
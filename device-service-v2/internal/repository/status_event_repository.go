@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"gorm.io/gorm"
+)
+
+type DeviceStatusEvent struct {
+	ID           string    `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID     string    `gorm:"type:varchar(36);not null;index:idx_status_events_device_time,priority:1"`
+	FromStatus   string    `gorm:"type:varchar(20);not null"`
+	ToStatus     string    `gorm:"type:varchar(20);not null"`
+	ErrorCode    string    `gorm:"type:varchar(64)"`
+	ErrorMessage string    `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"not null;index:idx_status_events_device_time,priority:2"`
+}
+
+func (e *DeviceStatusEvent) ToDomain() *models.DeviceStatusEvent {
+	if e == nil {
+		return nil
+	}
+	return &models.DeviceStatusEvent{
+		ID:           e.ID,
+		DeviceID:     e.DeviceID,
+		FromStatus:   e.FromStatus,
+		ToStatus:     e.ToStatus,
+		ErrorCode:    e.ErrorCode,
+		ErrorMessage: e.ErrorMessage,
+		CreatedAt:    e.CreatedAt,
+	}
+}
+
+type StatusEventRepository interface {
+	FindByDeviceID(deviceID string, from, to time.Time) ([]*models.DeviceStatusEvent, error)
+	FindLastBefore(deviceID string, before time.Time) (*models.DeviceStatusEvent, error)
+}
+
+type statusEventRepo struct {
+	db *gorm.DB
+}
+
+func NewStatusEventRepository(db *gorm.DB) StatusEventRepository {
+	return &statusEventRepo{db: db}
+}
+
+func (r *statusEventRepo) FindByDeviceID(deviceID string, from, to time.Time) ([]*models.DeviceStatusEvent, error) {
+	var events []DeviceStatusEvent
+	if err := r.db.
+		Where("device_id = ? AND created_at >= ? AND created_at < ?", deviceID, from, to).
+		Order("created_at ASC").
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.DeviceStatusEvent, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *statusEventRepo) FindLastBefore(deviceID string, before time.Time) (*models.DeviceStatusEvent, error) {
+	var event DeviceStatusEvent
+	if err := r.db.
+		Where("device_id = ? AND created_at < ?", deviceID, before).
+		Order("created_at DESC").
+		First(&event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return event.ToDomain(), nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+)
+
+// deviceAuthorizer is the one place that decides whether a caller may use a
+// device. Every service method that takes a device ID from a user goes
+// through it. A device owned by someone else is reported as
+// ErrDeviceNotFound, exactly like a missing one, so IDs cannot be probed.
+type deviceAuthorizer struct {
+	devices repository.DeviceRepository
+}
+
+// device returns deviceID when it belongs to ownerID.
+func (a deviceAuthorizer) device(ownerID, deviceID string) (*models.Device, error) {
+	d, err := a.devices.FindByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil || ownerID == "" || d.OwnerID != ownerID {
+		return nil, ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+// deletedDevice is device for soft-deleted devices awaiting their purge.
+func (a deviceAuthorizer) deletedDevice(ownerID, deviceID string) (*models.Device, error) {
+	d, err := a.devices.FindDeletedByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil || ownerID == "" || d.OwnerID != ownerID {
+		return nil, ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+// devicesOf checks every ID in deviceIDs and fails on the first one the caller
+// may not see.
+func (a deviceAuthorizer) devicesOf(ownerID string, deviceIDs []string) error {
+	for _, id := range deviceIDs {
+		if _, err := a.device(ownerID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
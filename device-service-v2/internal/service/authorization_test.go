@@ -0,0 +1,202 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+)
+
+const (
+	alice = "11111111-1111-1111-1111-111111111111"
+	bob   = "22222222-2222-2222-2222-222222222222"
+)
+
+// newAuthzFixture returns a fresh set of services over one device owned by
+// alice ("dev-alice"), so that every operation starts from the same state.
+func newAuthzFixture() (*DeviceService, *CommandService, *ShadowService, *DeletionService) {
+	devices := newFakeDevices(&models.Device{ID: "dev-alice", OwnerID: alice, Status: models.StatusOnline})
+	deviceSvc := NewDeviceService(devices, fakeStatusEvents{}, nil, nil, BrokerOptions{})
+	commandSvc := NewCommandService(newFakeCommands(), devices, nil)
+	shadowSvc := NewShadowService(fakeShadows{}, devices, nil)
+	deletionSvc := NewDeletionService(devices, nil, time.Hour)
+	return deviceSvc, commandSvc, shadowSvc, deletionSvc
+}
+
+func TestDeviceOwnership(t *testing.T) {
+	operations := []struct {
+		name string
+		call func(ownerID, deviceID string) error
+	}{
+		{"get", func(ownerID, deviceID string) error {
+			deviceSvc, _, _, _ := newAuthzFixture()
+			_, err := deviceSvc.GetDeviceWithID(ownerID, deviceID)
+			return err
+		}},
+		{"update", func(ownerID, deviceID string) error {
+			deviceSvc, _, _, _ := newAuthzFixture()
+			_, err := deviceSvc.UpdateDevice(ownerID, deviceID, "renamed", &models.DeviceMetadataPatch{})
+			return err
+		}},
+		{"status history", func(ownerID, deviceID string) error {
+			deviceSvc, _, _, _ := newAuthzFixture()
+			now := time.Now()
+			_, err := deviceSvc.GetStatusHistory(ownerID, deviceID, now.Add(-time.Hour), now)
+			return err
+		}},
+		{"stream filter", func(ownerID, deviceID string) error {
+			deviceSvc, _, _, _ := newAuthzFixture()
+			_, err := deviceSvc.StreamFilter(ownerID, "", []string{deviceID})
+			return err
+		}},
+		{"delete", func(ownerID, deviceID string) error {
+			_, _, _, deletionSvc := newAuthzFixture()
+			_, err := deletionSvc.DeleteDevice(ownerID, deviceID)
+			return err
+		}},
+		{"enqueue command", func(ownerID, deviceID string) error {
+			_, commandSvc, _, _ := newAuthzFixture()
+			_, err := commandSvc.EnqueueCommand(ownerID, deviceID, models.CommandReboot, nil, 0)
+			return err
+		}},
+		{"list commands", func(ownerID, deviceID string) error {
+			_, commandSvc, _, _ := newAuthzFixture()
+			_, err := commandSvc.ListCommands(ownerID, deviceID)
+			return err
+		}},
+		{"get shadow", func(ownerID, deviceID string) error {
+			_, _, shadowSvc, _ := newAuthzFixture()
+			_, err := shadowSvc.GetShadow(ownerID, deviceID)
+			return err
+		}},
+		{"update shadow", func(ownerID, deviceID string) error {
+			_, _, shadowSvc, _ := newAuthzFixture()
+			_, err := shadowSvc.UpdateDesired(ownerID, deviceID, map[string]interface{}{}, nil)
+			return err
+		}},
+	}
+
+	callers := []struct {
+		name     string
+		ownerID  string
+		deviceID string
+		want     error
+	}{
+		{"owner", alice, "dev-alice", nil},
+		{"other user", bob, "dev-alice", ErrDeviceNotFound},
+		{"missing device", alice, "dev-missing", ErrDeviceNotFound},
+		{"no owner", "", "dev-alice", ErrDeviceNotFound},
+	}
+
+	for _, op := range operations {
+		for _, caller := range callers {
+			t.Run(op.name+"/"+caller.name, func(t *testing.T) {
+				if err := op.call(caller.ownerID, caller.deviceID); err != caller.want {
+					t.Fatalf("got %v, want %v", err, caller.want)
+				}
+			})
+		}
+	}
+}
+
+func TestRestoreOwnership(t *testing.T) {
+	recent := time.Now().Add(-time.Minute)
+	old := time.Now().Add(-2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		ownerID   string
+		deletedAt *time.Time
+		want      error
+	}{
+		{"owner within window", alice, &recent, nil},
+		{"other user", bob, &recent, ErrDeviceNotFound},
+		{"owner after window", alice, &old, ErrRestoreExpired},
+		{"not deleted", alice, nil, ErrDeviceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices := newFakeDevices(&models.Device{ID: "dev-alice", OwnerID: alice, DeletedAt: tt.deletedAt})
+			svc := NewDeletionService(devices, nil, time.Hour)
+
+			d, err := svc.RestoreDevice(tt.ownerID, "dev-alice")
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if err == nil && d.DeletedAt != nil {
+				t.Fatalf("restored device still marked deleted")
+			}
+		})
+	}
+}
+
+// deletingDevices soft-deletes a device right before updating it, as if a
+// delete request won the race against the update.
+type deletingDevices struct {
+	*fakeDevices
+}
+
+func (f deletingDevices) UpdateDevice(ownerID, deviceID, deviceName string, metadata models.DeviceMetadata, setFirmware bool) (*models.Device, error) {
+	f.SoftDelete(ownerID, deviceID)
+	return f.fakeDevices.UpdateDevice(ownerID, deviceID, deviceName, metadata, setFirmware)
+}
+
+func TestUpdateDeletedDevice(t *testing.T) {
+	deleted := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		devices repository.DeviceRepository
+	}{
+		{"deleted before", newFakeDevices(&models.Device{ID: "dev-alice", OwnerID: alice, DeletedAt: &deleted})},
+		{"deleted during update", deletingDevices{newFakeDevices(&models.Device{ID: "dev-alice", OwnerID: alice})}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewDeviceService(tt.devices, fakeStatusEvents{}, nil, nil, BrokerOptions{})
+			if _, err := svc.UpdateDevice(alice, "dev-alice", "renamed", &models.DeviceMetadataPatch{}); err != ErrDeviceNotFound {
+				t.Fatalf("got %v, want %v", err, ErrDeviceNotFound)
+			}
+		})
+	}
+}
+
+func TestStreamFilterRejectsForeignDevices(t *testing.T) {
+	devices := newFakeDevices(
+		&models.Device{ID: "dev-alice", OwnerID: alice},
+		&models.Device{ID: "dev-bob", OwnerID: bob},
+	)
+	svc := NewDeviceService(devices, fakeStatusEvents{}, nil, nil, BrokerOptions{})
+
+	tests := []struct {
+		name      string
+		deviceIDs []string
+		want      map[string]struct{}
+		wantErr   error
+	}{
+		{"all devices", nil, nil, nil},
+		{"own device", []string{"dev-alice"}, map[string]struct{}{"dev-alice": {}}, nil},
+		{"mixed with foreign device", []string{"dev-alice", "dev-bob"}, nil, ErrDeviceNotFound},
+		{"foreign device", []string{"dev-bob"}, nil, ErrDeviceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.StreamFilter(alice, "", tt.deviceIDs)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for id := range tt.want {
+				if _, ok := got[id]; !ok {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,34 +1,377 @@
 package service
 
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DXR3IN/device-service-v2/internal/pubsub"
+)
+
+// Stream event names, as sent in the SSE "event:" field.
+const (
+	EventDeviceStatus = "device_status_update"
+	EventCommand      = "command_update"
+	EventShadow       = "shadow_update"
+)
+
+// DefaultSubscriberBuffer is how many events a subscriber may fall behind
+// before the slow-consumer policy applies.
+const DefaultSubscriberBuffer = 64
+
+// replayBufferSize is how many recent events the broker keeps so that a
+// reconnecting client can catch up from its Last-Event-ID.
+const replayBufferSize = 256
+
+// SlowConsumerPolicy decides what happens when a subscriber's buffer is full.
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// DropNewest discards the event being published.
+	DropNewest SlowConsumerPolicy = "drop_newest"
+	// Disconnect closes the subscription; the client reconnects and resumes
+	// from its Last-Event-ID.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+func IsValidSlowConsumerPolicy(p string) bool {
+	switch SlowConsumerPolicy(p) {
+	case DropOldest, DropNewest, Disconnect:
+		return true
+	}
+	return false
+}
+
+// BrokerOptions configures a Broker. Zero values mean an in-memory backend,
+// DefaultSubscriberBuffer and DropOldest.
+type BrokerOptions struct {
+	Backend    BrokerBackend
+	BufferSize int
+	Policy     SlowConsumerPolicy
+}
+
+// Event is a notification about one device. The broker only hands it to
+// subscribers of the device's owner. ID is assigned by the broker and
+// increases with every published event; it is 0 for an event the backend
+// failed to publish.
+type Event struct {
+	ID       uint64
+	Name     string
+	OwnerID  string
+	DeviceID string
+	Data     interface{}
+}
+
+// Subscription is one connected stream client. A nil DeviceIDs set means
+// every device of the owner. Closed is closed when the broker disconnects a
+// slow subscriber.
+type Subscription struct {
+	OwnerID   string
+	DeviceIDs map[string]struct{}
+	Events    chan *Event
+	Closed    chan struct{}
+
+	id          uint64
+	since       time.Time
+	enqueued    uint64
+	dropped     uint64
+	lastEventID uint64
+	replay      chan replay
+}
+
+// SubscriptionStats describes one subscription for the debug endpoint.
+type SubscriptionStats struct {
+	ID            uint64    `json:"id"`
+	OwnerID       string    `json:"owner_id"`
+	DeviceIDs     []string  `json:"device_ids,omitempty"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	QueueDepth    int       `json:"queue_depth"`
+	QueueCapacity int       `json:"queue_capacity"`
+	Enqueued      uint64    `json:"enqueued"`
+	Dropped       uint64    `json:"dropped"`
+}
+
+// replay is what a new subscriber missed since its Last-Event-ID. Resumed is
+// false when the ID is unknown or already evicted from the replay buffer.
+type replay struct {
+	Events  []*Event
+	Resumed bool
+}
+
+func (s *Subscription) wants(ev *Event) bool {
+	if ev.OwnerID != s.OwnerID {
+		return false
+	}
+	if s.DeviceIDs == nil {
+		return true
+	}
+	_, ok := s.DeviceIDs[ev.DeviceID]
+	return ok
+}
+
+// BrokerBackend carries published events between service instances. Every
+// instance, the publisher included, receives each message from Messages.
+type BrokerBackend interface {
+	Publish(payload []byte) error
+	Messages() <-chan pubsub.Message
+}
+
+// wireEvent is how an Event travels through a BrokerBackend. Data arrives
+// on the other side as raw JSON, which renders the same on every stream.
+type wireEvent struct {
+	Name     string          `json:"name"`
+	OwnerID  string          `json:"owner_id"`
+	DeviceID string          `json:"device_id"`
+	Data     json.RawMessage `json:"data"`
+}
+
 type Broker struct {
-	Notifier       chan interface{}
-	Clients        map[chan interface{}]bool
-	NewClients     chan chan interface{}
-	ClosingClients chan chan interface{}
+	Notifier       chan *Event
+	NewClients     chan *Subscription
+	ClosingClients chan *Subscription
+
+	// clients, seq, history and nextID are only touched by listen. clients
+	// is keyed by owner ID; history holds the last replayBufferSize events.
+	clients map[string]map[*Subscription]bool
+	seq     uint64
+	history []*Event
+	nextID  uint64
+	stats   chan chan []SubscriptionStats
+
+	// backend is nil when events only fan out inside this process. resync
+	// raises seq after the backend lost events while reconnecting.
+	backend    BrokerBackend
+	resync     chan uint64
+	bufferSize int
+	policy     SlowConsumerPolicy
 }
 
-func NewBroker() *Broker {
+func NewBroker(opts BrokerOptions) *Broker {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSubscriberBuffer
+	}
+	if opts.Policy == "" {
+		opts.Policy = DropOldest
+	}
 	b := &Broker{
-		Notifier:       make(chan interface{}, 1),
-		Clients:        make(map[chan interface{}]bool),
-		NewClients:     make(chan chan interface{}),
-		ClosingClients: make(chan chan interface{}),
+		Notifier:       make(chan *Event, 1),
+		NewClients:     make(chan *Subscription),
+		ClosingClients: make(chan *Subscription),
+		clients:        make(map[string]map[*Subscription]bool),
+		stats:          make(chan chan []SubscriptionStats),
+		backend:        opts.Backend,
+		resync:         make(chan uint64),
+		bufferSize:     opts.BufferSize,
+		policy:         opts.Policy,
 	}
 	go b.listen()
+	if b.backend != nil {
+		go b.receive()
+	}
 	return b
 }
 
+// Subscribe registers a client for the owner's events, optionally limited to
+// deviceIDs. A non-zero lastEventID asks for the matching events published
+// after it; resumed reports whether the gap could be filled completely.
+// Call Unsubscribe when the client goes away.
+func (b *Broker) Subscribe(ownerID string, deviceIDs map[string]struct{}, lastEventID uint64) (sub *Subscription, missed []*Event, resumed bool) {
+	sub = &Subscription{
+		OwnerID:     ownerID,
+		DeviceIDs:   deviceIDs,
+		Events:      make(chan *Event, b.bufferSize),
+		Closed:      make(chan struct{}),
+		since:       time.Now(),
+		lastEventID: lastEventID,
+		replay:      make(chan replay, 1),
+	}
+	b.NewClients <- sub
+	r := <-sub.replay
+	return sub, r.Events, r.Resumed
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.ClosingClients <- sub
+}
+
+// Publish fans ev out. Without a backend, or when the backend fails, it goes
+// to this instance's subscribers only.
+func (b *Broker) Publish(ev *Event) {
+	if b.backend != nil {
+		data, err := json.Marshal(ev.Data)
+		var payload []byte
+		if err == nil {
+			payload, err = json.Marshal(wireEvent{Name: ev.Name, OwnerID: ev.OwnerID, DeviceID: ev.DeviceID, Data: data})
+		}
+		if err == nil {
+			err = b.backend.Publish(payload)
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("broker: backend publish failed, delivering locally: %v", err)
+	}
+	b.Notifier <- ev
+}
+
+// Subscriptions lists the active subscriptions, oldest first.
+func (b *Broker) Subscriptions() []SubscriptionStats {
+	reply := make(chan []SubscriptionStats, 1)
+	b.stats <- reply
+	return <-reply
+}
+
+func (b *Broker) receive() {
+	for m := range b.backend.Messages() {
+		if m.Payload == nil {
+			b.resync <- m.ID
+			continue
+		}
+		var w wireEvent
+		if err := json.Unmarshal(m.Payload, &w); err != nil {
+			log.Printf("broker: dropping undecodable event %d: %v", m.ID, err)
+			continue
+		}
+		b.Notifier <- &Event{ID: m.ID, Name: w.Name, OwnerID: w.OwnerID, DeviceID: w.DeviceID, Data: w.Data}
+	}
+}
+
 func (b *Broker) listen() {
 	for {
 		select {
 		case s := <-b.NewClients:
-			b.Clients[s] = true
+			b.nextID++
+			s.id = b.nextID
+			if b.clients[s.OwnerID] == nil {
+				b.clients[s.OwnerID] = make(map[*Subscription]bool)
+			}
+			b.clients[s.OwnerID][s] = true
+			s.replay <- b.missed(s)
 		case s := <-b.ClosingClients:
-			delete(b.Clients, s)
-		case data := <-b.Notifier:
-			for clientChan := range b.Clients {
-				clientChan <- data
+			b.remove(s)
+		case reply := <-b.stats:
+			reply <- b.subscriptionStats()
+		case id := <-b.resync:
+			if id > b.seq {
+				b.seq = id
+			}
+		case ev := <-b.Notifier:
+			switch {
+			case ev.ID != 0:
+				// Backend events carry IDs from a shared sequence; keep seq
+				// at the highest one seen so Last-Event-ID checks stay valid.
+				if ev.ID > b.seq {
+					b.seq = ev.ID
+				}
+			case b.backend == nil:
+				b.seq++
+				ev.ID = b.seq
+			}
+			// An event the backend failed to publish has no ID: one of ours
+			// would collide with the shared sequence, so it is sent without
+			// one and never replayed.
+			if ev.ID != 0 {
+				if len(b.history) == replayBufferSize {
+					b.history = b.history[1:]
+				}
+				b.history = append(b.history, ev)
+			}
+
+			for s := range b.clients[ev.OwnerID] {
+				if s.wants(ev) {
+					b.deliver(s, ev)
+				}
 			}
 		}
 	}
 }
+
+// deliver never blocks the broker: when s is full the policy decides which
+// event is lost, or whether s is cut off.
+func (b *Broker) deliver(s *Subscription, ev *Event) {
+	select {
+	case s.Events <- ev:
+		s.enqueued++
+		return
+	default:
+	}
+
+	s.dropped++
+	switch b.policy {
+	case DropOldest:
+		select {
+		case <-s.Events:
+		default:
+		}
+		select {
+		case s.Events <- ev:
+			s.enqueued++
+		default:
+		}
+	case Disconnect:
+		b.remove(s)
+		close(s.Closed)
+	}
+}
+
+func (b *Broker) remove(s *Subscription) {
+	delete(b.clients[s.OwnerID], s)
+	if len(b.clients[s.OwnerID]) == 0 {
+		delete(b.clients, s.OwnerID)
+	}
+}
+
+func (b *Broker) subscriptionStats() []SubscriptionStats {
+	stats := []SubscriptionStats{}
+	for _, subs := range b.clients {
+		for s := range subs {
+			st := SubscriptionStats{
+				ID:            s.id,
+				OwnerID:       s.OwnerID,
+				ConnectedAt:   s.since,
+				QueueDepth:    len(s.Events),
+				QueueCapacity: cap(s.Events),
+				Enqueued:      s.enqueued,
+				Dropped:       s.dropped,
+			}
+			for id := range s.DeviceIDs {
+				st.DeviceIDs = append(st.DeviceIDs, id)
+			}
+			sort.Strings(st.DeviceIDs)
+			stats = append(stats, st)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// missed collects the events s has not seen yet. Registration and replay
+// happen in the same listen step, so nothing falls between them.
+func (b *Broker) missed(s *Subscription) replay {
+	if s.lastEventID == 0 || s.lastEventID > b.seq {
+		return replay{}
+	}
+	// Every event after lastEventID must still be in history. A gap means
+	// events were evicted or never reached this instance.
+	next := s.lastEventID + 1
+	var events []*Event
+	for _, ev := range b.history {
+		if ev.ID <= s.lastEventID {
+			continue
+		}
+		if ev.ID != next {
+			return replay{}
+		}
+		next++
+		if s.wants(ev) {
+			events = append(events, ev)
+		}
+	}
+	if next != b.seq+1 {
+		return replay{}
+	}
+	return replay{Events: events, Resumed: true}
+}
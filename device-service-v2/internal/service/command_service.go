@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultCommandTTL = 5 * time.Minute
+	MaxCommandTTL     = 24 * time.Hour
+	commandListLimit  = 100
+)
+
+var (
+	ErrInvalidCommand  = errors.New("invalid command")
+	ErrCommandNotFound = errors.New("command not found")
+	ErrCommandClosed   = errors.New("command already completed")
+)
+
+type CommandService struct {
+	repo    repository.CommandRepository
+	devices repository.DeviceRepository
+	auth    deviceAuthorizer
+	Broker  *Broker
+}
+
+func NewCommandService(r repository.CommandRepository, devices repository.DeviceRepository, broker *Broker) *CommandService {
+	return &CommandService{repo: r, devices: devices, auth: deviceAuthorizer{devices: devices}, Broker: broker}
+}
+
+func (s *CommandService) EnqueueCommand(ownerID, deviceID, commandType string, params map[string]interface{}, ttl time.Duration) (*models.Command, error) {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if err := models.ValidateCommand(commandType, params); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+	if ttl == 0 {
+		ttl = DefaultCommandTTL
+	}
+	if ttl < 0 || ttl > MaxCommandTTL {
+		return nil, fmt.Errorf("%w: ttl must be between 1s and %s", ErrInvalidCommand, MaxCommandTTL)
+	}
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c := &repository.Command{
+		ID:        uuid.New().String(),
+		DeviceID:  deviceID,
+		OwnerID:   ownerID,
+		Type:      commandType,
+		Params:    params,
+		Status:    models.CommandQueued,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.repo.Create(c); err != nil {
+		return nil, err
+	}
+	command := c.ToDomain()
+	s.publish(command, now)
+	return command, nil
+}
+
+func (s *CommandService) ListCommands(ownerID, deviceID string) ([]*models.Command, error) {
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByDeviceID(deviceID, commandListLimit)
+}
+
+func (s *CommandService) GetCommand(ownerID, deviceID, commandID string) (*models.Command, error) {
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	c, err := s.repo.FindByID(commandID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.DeviceID != deviceID {
+		return nil, ErrCommandNotFound
+	}
+	return c, nil
+}
+
+// FetchPendingCommands hands the device its queued commands and marks them
+// delivered.
+func (s *CommandService) FetchPendingCommands(deviceID string) ([]*models.Command, error) {
+	d, err := s.devices.FindByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
+	now := time.Now()
+	commands, err := s.repo.DeliverPending(deviceID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range commands {
+		s.publish(c, now)
+	}
+	return commands, nil
+}
+
+// AcknowledgeCommand records the outcome the device reported for a command.
+func (s *CommandService) AcknowledgeCommand(deviceID, commandID string, success bool, result map[string]interface{}) (*models.Command, error) {
+	status := models.CommandFailed
+	if success {
+		status = models.CommandSucceeded
+	}
+	now := time.Now()
+	c, err := s.repo.Complete(commandID, deviceID, status, result, now)
+	if err != nil {
+		if errors.Is(err, repository.ErrCommandClosed) {
+			return nil, ErrCommandClosed
+		}
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrCommandNotFound
+	}
+	s.publish(c, now)
+	return c, nil
+}
+
+// RunExpiry expires overdue commands every interval. It never returns.
+func (s *CommandService) RunExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		expired, err := s.repo.ExpireDue(now)
+		if err != nil {
+			log.Printf("CommandService: expiring commands failed: %v", err)
+			continue
+		}
+		for _, c := range expired {
+			s.publish(c, now)
+		}
+	}
+}
+
+func (s *CommandService) publish(c *models.Command, at time.Time) {
+	if s.Broker == nil {
+		return
+	}
+	s.Broker.Publish(&Event{
+		Name:     EventCommand,
+		OwnerID:  c.OwnerID,
+		DeviceID: c.DeviceID,
+		Data: &models.CommandNotification{
+			ID:        c.ID,
+			DeviceID:  c.DeviceID,
+			OwnerID:   c.OwnerID,
+			Type:      c.Type,
+			Status:    c.Status,
+			Result:    c.Result,
+			UpdatedAt: at,
+		},
+	})
+}
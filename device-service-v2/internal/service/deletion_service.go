@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/DXR3IN/device-service-v2/internal/client"
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+)
+
+var ErrRestoreExpired = errors.New("restore window has passed")
+
+// DeletionService soft-deletes devices and purges them once their restore
+// window has passed. A purge first tells telemetry-service-v2, so a device
+// whose notification fails stays deleted-but-not-purged and is retried on
+// the next run.
+type DeletionService struct {
+	repo      repository.DeviceRepository
+	telemetry *client.TelemetryClient
+	window    time.Duration
+	auth      deviceAuthorizer
+}
+
+func NewDeletionService(r repository.DeviceRepository, telemetry *client.TelemetryClient, window time.Duration) *DeletionService {
+	return &DeletionService{repo: r, telemetry: telemetry, window: window, auth: deviceAuthorizer{devices: r}}
+}
+
+func (s *DeletionService) DeleteDevice(ownerID, deviceID string) (*models.DeletedDevice, error) {
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	d, err := s.repo.SoftDelete(ownerID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
+	return s.deleted(d), nil
+}
+
+func (s *DeletionService) ListDeletedDevices(ownerID string) ([]*models.DeletedDevice, error) {
+	devices, err := s.repo.FindDeletedByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*models.DeletedDevice, 0, len(devices))
+	for _, d := range devices {
+		result = append(result, s.deleted(d))
+	}
+	return result, nil
+}
+
+func (s *DeletionService) RestoreDevice(ownerID, deviceID string) (*models.Device, error) {
+	d, err := s.auth.deletedDevice(ownerID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.After(s.deleted(d).RestoreBefore) {
+		return nil, ErrRestoreExpired
+	}
+	// The database checks the window again: once purgeDue may have picked
+	// the device up, it can no longer come back.
+	restored, err := s.repo.Restore(deviceID, now.Add(-s.window))
+	if err != nil {
+		return nil, err
+	}
+	if restored == nil {
+		// The window passed or the device was purged after the lookup.
+		return nil, ErrRestoreExpired
+	}
+	return restored, nil
+}
+
+// RunPurge purges devices past their restore window every interval. It never
+// returns.
+func (s *DeletionService) RunPurge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.purgeDue()
+	}
+}
+
+func (s *DeletionService) purgeDue() {
+	due, err := s.repo.FindDeletedBefore(time.Now().Add(-s.window))
+	if err != nil {
+		log.Printf("DeletionService: listing deleted devices failed: %v", err)
+		return
+	}
+	for _, d := range due {
+		if err := s.telemetry.DeviceDeleted(d.ID); err != nil {
+			log.Printf("DeletionService: telemetry cleanup for %s failed, will retry: %v", d.ID, err)
+			continue
+		}
+		if err := s.repo.Purge(d.ID); err == repository.ErrNotDeleted {
+			log.Printf("DeletionService: %s was restored before its purge", d.ID)
+		} else if err != nil {
+			log.Printf("DeletionService: purging %s failed: %v", d.ID, err)
+		}
+	}
+}
+
+func (s *DeletionService) deleted(d *models.Device) *models.DeletedDevice {
+	return &models.DeletedDevice{Device: d, RestoreBefore: d.DeletedAt.Add(s.window)}
+}
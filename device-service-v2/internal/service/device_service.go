@@ -2,6 +2,8 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	models "github.com/DXR3IN/device-service-v2/internal/domain"
@@ -10,21 +12,36 @@ import (
 )
 
 var (
-	ErrDeviceExists   = errors.New("device already exists")
-	ErrDeviceNotFound = errors.New("device not found")
+	ErrDeviceExists      = errors.New("device already exists")
+	ErrDeviceNotFound    = errors.New("device not found")
+	ErrInvalidStatus     = errors.New("invalid device status")
+	ErrInvalidTransition = errors.New("invalid device status transition")
+	ErrStatusConflict    = errors.New("device status changed concurrently")
+	ErrInvalidMetadata   = errors.New("invalid device metadata")
+	ErrDeviceDeleted     = errors.New("device is deleted")
 )
 
 type DeviceService struct {
 	repo   repository.DeviceRepository
+	events repository.StatusEventRepository
+	groups repository.GroupRepository
 	jwt    *utils.JWTManager
+	auth   deviceAuthorizer
 	Broker *Broker
 }
 
-func NewDeviceService(r repository.DeviceRepository, jwt *utils.JWTManager) *DeviceService {
-	return &DeviceService{repo: r, jwt: jwt}
+// NewDeviceService creates the service and its stream broker.
+func NewDeviceService(r repository.DeviceRepository, events repository.StatusEventRepository, groups repository.GroupRepository, jwt *utils.JWTManager, broker BrokerOptions) *DeviceService {
+	return &DeviceService{repo: r, events: events, groups: groups, jwt: jwt, auth: deviceAuthorizer{devices: r}, Broker: NewBroker(broker)}
 }
 
-func (s *DeviceService) CreateDevice(deviceID string, deviceName, ownerID string) (*repository.Device, error) {
+func (s *DeviceService) CreateDevice(deviceID string, deviceName, ownerID string, metadata *models.DeviceMetadataPatch) (*models.Device, error) {
+	var meta models.DeviceMetadata
+	metadata.Apply(&meta)
+	if err := validateMetadata(&meta); err != nil {
+		return nil, err
+	}
+
 	ex, err := s.repo.FindByID(deviceID)
 	if err != nil {
 		return nil, err
@@ -32,81 +49,232 @@ func (s *DeviceService) CreateDevice(deviceID string, deviceName, ownerID string
 	if ex != nil {
 		return nil, ErrDeviceExists
 	}
+	// A deleted device keeps its ID until it is purged.
+	deleted, err := s.repo.FindDeletedByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted != nil {
+		return nil, ErrDeviceDeleted
+	}
 
 	// d is a device variabel
-	d := &repository.Device{ID: deviceID, DeviceName: deviceName, OwnerID: ownerID}
+	d := &repository.Device{
+		ID:         deviceID,
+		DeviceName: deviceName,
+		OwnerID:    ownerID,
+		Status:     models.StatusProvisioning,
+		Metadata:   repository.ToRepositoryMetadata(meta),
+	}
 	if err := s.repo.Create(d); err != nil {
 		return nil, err
 	}
 
-	return d, nil
+	return d.ToDomain(), nil
 }
 
-func (s *DeviceService) GetDeviceWithID(deviceID string) (*models.Device, error) {
-	// d is a device variable
+func (s *DeviceService) GetDeviceWithID(ownerID, deviceID string) (*models.Device, error) {
+	return s.auth.device(ownerID, deviceID)
+}
+
+// DeviceOwner tells other services who owns a device. Deleted devices have
+// no owner.
+func (s *DeviceService) DeviceOwner(deviceID string) (string, error) {
 	d, err := s.repo.FindByID(deviceID)
-	if d == nil {
-		return nil, ErrDeviceNotFound
-	}
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return d, nil
+	if d == nil {
+		return "", ErrDeviceNotFound
+	}
+	return d.OwnerID, nil
 }
 
-func (s *DeviceService) GetAllDeviceWithOwnerID(ownerID string) ([]*models.Device, error) {
+// GetAllDeviceWithOwnerID lists the owner's devices, limited to the members of
+// groupID when it is not empty.
+func (s *DeviceService) GetAllDeviceWithOwnerID(ownerID, groupID string) ([]*models.Device, error) {
 	// d is a device variable
 	d, err := s.repo.FindByOwnerID(ownerID)
-	if d == nil {
+	if err != nil {
+		return nil, err
+	}
+	if groupID != "" {
+		members, err := s.GroupDeviceIDs(ownerID, groupID)
+		if err != nil {
+			return nil, err
+		}
+		filtered := make([]*models.Device, 0, len(d))
+		for _, device := range d {
+			if _, ok := members[device.ID]; ok {
+				filtered = append(filtered, device)
+			}
+		}
+		d = filtered
+	}
+	if len(d) == 0 {
 		return nil, ErrDeviceNotFound
 	}
+
+	return d, nil
+}
+
+// GroupDeviceIDs returns the IDs of the devices in one of the owner's groups.
+func (s *DeviceService) GroupDeviceIDs(ownerID, groupID string) (map[string]struct{}, error) {
+	g, err := s.groups.FindByID(groupID)
 	if err != nil {
 		return nil, err
 	}
+	if g == nil || g.OwnerID != ownerID {
+		return nil, ErrGroupNotFound
+	}
+	ids := make(map[string]struct{}, len(g.DeviceIDs))
+	for _, id := range g.DeviceIDs {
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
 
-	return d, nil
+// StreamFilter resolves the devices a stream subscriber asked for. It returns
+// nil when neither a group nor device IDs are given, which means every device
+// of the owner. When both are given only devices in the group are kept.
+func (s *DeviceService) StreamFilter(ownerID, groupID string, deviceIDs []string) (map[string]struct{}, error) {
+	var ids map[string]struct{}
+	if groupID != "" {
+		members, err := s.GroupDeviceIDs(ownerID, groupID)
+		if err != nil {
+			return nil, err
+		}
+		ids = members
+	}
+	if len(deviceIDs) == 0 {
+		return ids, nil
+	}
+
+	if err := s.auth.devicesOf(ownerID, deviceIDs); err != nil {
+		return nil, err
+	}
+	requested := make(map[string]struct{}, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if ids != nil {
+			if _, ok := ids[id]; !ok {
+				continue
+			}
+		}
+		requested[id] = struct{}{}
+	}
+	return requested, nil
 }
 
-func (s *DeviceService) DeleteDevicesByOwnerID(ownerID string) error {
-	return s.repo.DeleteByOwnerID(ownerID)
+// StreamSnapshot returns the current status of the devices a stream client
+// subscribed to, sent before any live events.
+func (s *DeviceService) StreamSnapshot(ownerID string, deviceIDs map[string]struct{}) ([]*models.DeviceStatusNotification, error) {
+	devices, err := s.repo.FindByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make([]*models.DeviceStatusNotification, 0, len(devices))
+	for _, d := range devices {
+		if deviceIDs != nil {
+			if _, ok := deviceIDs[d.ID]; !ok {
+				continue
+			}
+		}
+		snapshot = append(snapshot, statusNotification(d))
+	}
+	return snapshot, nil
 }
 
-func (s *DeviceService) UpdateDevice(ownerID, deviceID, deviceName string) (*models.Device, error) {
-	_, err := s.repo.FindByID(deviceID)
+func statusNotification(d *models.Device) *models.DeviceStatusNotification {
+	return &models.DeviceStatusNotification{
+		ID:             d.ID,
+		Status:         d.Status,
+		ErrorCode:      d.ErrorCode,
+		ErrorMessage:   d.ErrorMessage,
+		DeviceName:     d.DeviceName,
+		DeviceMetadata: d.DeviceMetadata,
+		UpdatedAt:      d.UpdatedAt,
+	}
+}
+
+func (s *DeviceService) UpdateDevice(ownerID, deviceID, deviceName string, metadata *models.DeviceMetadataPatch) (*models.Device, error) {
+	current, err := s.auth.device(ownerID, deviceID)
 	if err != nil {
 		return nil, err
 	}
 
-	d, err := s.repo.UpdateDevice(ownerID, deviceID, deviceName)
+	meta := current.DeviceMetadata
+	metadata.Apply(&meta)
+	if err := validateMetadata(&meta); err != nil {
+		return nil, err
+	}
+
+	d, err := s.repo.UpdateDevice(ownerID, deviceID, deviceName, meta, metadata != nil && metadata.FirmwareVersion != nil)
 	if err != nil {
 		return nil, err
 	}
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
 	return d, nil
 }
 
-func (s *DeviceService) UpdateDeviceStatusByID(deviceID, status string) (*models.Device, error) {
-	_, err := s.repo.FindByID(deviceID)
+func validateMetadata(m *models.DeviceMetadata) error {
+	if m.SystemType != "" && !models.IsValidSystemType(m.SystemType) {
+		return fmt.Errorf("%w: system_type must be one of %s", ErrInvalidMetadata, strings.Join(models.SystemTypes(), ", "))
+	}
+	if (m.Latitude == nil) != (m.Longitude == nil) {
+		return fmt.Errorf("%w: latitude and longitude must be set together", ErrInvalidMetadata)
+	}
+	if m.Latitude != nil && (*m.Latitude < -90 || *m.Latitude > 90) {
+		return fmt.Errorf("%w: latitude must be between -90 and 90", ErrInvalidMetadata)
+	}
+	if m.Longitude != nil && (*m.Longitude < -180 || *m.Longitude > 180) {
+		return fmt.Errorf("%w: longitude must be between -180 and 180", ErrInvalidMetadata)
+	}
+	if m.ReservoirVolumeLiters != nil && *m.ReservoirVolumeLiters < 0 {
+		return fmt.Errorf("%w: reservoir_volume_liters must not be negative", ErrInvalidMetadata)
+	}
+	return nil
+}
+
+func (s *DeviceService) UpdateDeviceStatusByID(deviceID string, report *models.StatusReport) (*models.Device, error) {
+	if !models.IsValidStatus(report.Status) {
+		return nil, ErrInvalidStatus
+	}
+	if report.Status != models.StatusError {
+		report.ErrorCode = ""
+		report.ErrorMessage = ""
+	}
+
+	current, err := s.repo.FindByID(deviceID)
 	if err != nil {
 		return nil, err
 	}
+	if current == nil {
+		return nil, ErrDeviceNotFound
+	}
+	if !models.CanTransition(current.Status, report.Status) {
+		return nil, ErrInvalidTransition
+	}
 
-	d, err := s.repo.UpdateStatusByID(deviceID, status)
+	d, err := s.repo.UpdateStatusByID(deviceID, current.Status, report)
 	if err != nil {
+		if errors.Is(err, repository.ErrStatusChanged) {
+			return nil, ErrStatusConflict
+		}
 		return nil, err
 	}
-	if s.Broker != nil {
-		notification := struct {
-			ID        string    `json:"id"`
-			Status    string    `json:"status"`
-			UpdatedAt time.Time `json:"updated_at"`
-		}{
-			ID:        d.ID,
-			Status:    d.Status,
-			UpdatedAt: time.Now(),
-		}
-
-		s.Broker.Notifier <- notification
+	if d == nil {
+		return nil, ErrDeviceNotFound
 	}
+	n := statusNotification(d)
+	n.UpdatedAt = time.Now()
+	s.Broker.Publish(&Event{
+		Name:     EventDeviceStatus,
+		OwnerID:  d.OwnerID,
+		DeviceID: d.ID,
+		Data:     n,
+	})
 	return d, nil
 }
 
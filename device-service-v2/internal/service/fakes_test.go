@@ -0,0 +1,197 @@
+package service
+
+import (
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+)
+
+// fakeDevices is an in-memory DeviceRepository. Devices in deleted are
+// soft-deleted and invisible to the regular lookups.
+type fakeDevices struct {
+	live    map[string]*models.Device
+	deleted map[string]*models.Device
+}
+
+func newFakeDevices(devices ...*models.Device) *fakeDevices {
+	f := &fakeDevices{live: map[string]*models.Device{}, deleted: map[string]*models.Device{}}
+	for _, d := range devices {
+		if d.DeletedAt != nil {
+			f.deleted[d.ID] = d
+		} else {
+			f.live[d.ID] = d
+		}
+	}
+	return f
+}
+
+func (f *fakeDevices) Create(d *repository.Device) error {
+	f.live[d.ID] = d.ToDomain()
+	return nil
+}
+
+func (f *fakeDevices) FindByID(id string) (*models.Device, error) {
+	return f.live[id], nil
+}
+
+func (f *fakeDevices) FindByOwnerID(ownerID string) ([]*models.Device, error) {
+	var result []*models.Device
+	for _, d := range f.live {
+		if d.OwnerID == ownerID {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeDevices) SoftDelete(ownerID, deviceID string) (*models.Device, error) {
+	d := f.live[deviceID]
+	if d == nil || d.OwnerID != ownerID {
+		return nil, nil
+	}
+	now := time.Now()
+	d.DeletedAt = &now
+	delete(f.live, deviceID)
+	f.deleted[deviceID] = d
+	return d, nil
+}
+
+func (f *fakeDevices) FindDeletedByID(id string) (*models.Device, error) {
+	return f.deleted[id], nil
+}
+
+func (f *fakeDevices) FindDeletedByOwnerID(ownerID string) ([]*models.Device, error) {
+	var result []*models.Device
+	for _, d := range f.deleted {
+		if d.OwnerID == ownerID {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeDevices) FindDeletedBefore(cutoff time.Time) ([]*models.Device, error) {
+	var result []*models.Device
+	for _, d := range f.deleted {
+		if d.DeletedAt.Before(cutoff) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeDevices) Restore(deviceID string, deletedAfter time.Time) (*models.Device, error) {
+	d := f.deleted[deviceID]
+	if d == nil || d.DeletedAt.Before(deletedAfter) {
+		return nil, nil
+	}
+	d.DeletedAt = nil
+	delete(f.deleted, deviceID)
+	f.live[deviceID] = d
+	return d, nil
+}
+
+func (f *fakeDevices) Purge(deviceID string) error {
+	delete(f.deleted, deviceID)
+	return nil
+}
+
+func (f *fakeDevices) UpdateDevice(ownerID, deviceID, deviceName string, metadata models.DeviceMetadata, setFirmware bool) (*models.Device, error) {
+	d := f.live[deviceID]
+	if d == nil || d.OwnerID != ownerID {
+		return nil, nil
+	}
+	firmware := d.FirmwareVersion
+	d.DeviceName = deviceName
+	d.DeviceMetadata = metadata
+	if !setFirmware {
+		d.FirmwareVersion = firmware
+	}
+	return d, nil
+}
+
+func (f *fakeDevices) UpdateStatusByID(deviceID, expected string, report *models.StatusReport) (*models.Device, error) {
+	d := f.live[deviceID]
+	if d == nil {
+		return nil, nil
+	}
+	if d.Status != expected {
+		return nil, repository.ErrStatusChanged
+	}
+	d.Status = report.Status
+	return d, nil
+}
+
+func (f *fakeDevices) UpdateFirmwareVersion(deviceID, version string) error {
+	if d := f.live[deviceID]; d != nil {
+		d.FirmwareVersion = version
+	}
+	return nil
+}
+
+// fakeStatusEvents is a StatusEventRepository without any history.
+type fakeStatusEvents struct{}
+
+func (fakeStatusEvents) FindByDeviceID(deviceID string, from, to time.Time) ([]*models.DeviceStatusEvent, error) {
+	return nil, nil
+}
+
+func (fakeStatusEvents) FindLastBefore(deviceID string, before time.Time) (*models.DeviceStatusEvent, error) {
+	return nil, nil
+}
+
+// fakeCommands is an in-memory CommandRepository.
+type fakeCommands struct {
+	commands map[string]*models.Command
+}
+
+func newFakeCommands() *fakeCommands {
+	return &fakeCommands{commands: map[string]*models.Command{}}
+}
+
+func (f *fakeCommands) Create(c *repository.Command) error {
+	f.commands[c.ID] = c.ToDomain()
+	return nil
+}
+
+func (f *fakeCommands) FindByID(id string) (*models.Command, error) {
+	return f.commands[id], nil
+}
+
+func (f *fakeCommands) FindByDeviceID(deviceID string, limit int) ([]*models.Command, error) {
+	var result []*models.Command
+	for _, c := range f.commands {
+		if c.DeviceID == deviceID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeCommands) DeliverPending(deviceID string, now time.Time) ([]*models.Command, error) {
+	return nil, nil
+}
+
+func (f *fakeCommands) Complete(id, deviceID, status string, result map[string]interface{}, now time.Time) (*models.Command, error) {
+	return nil, nil
+}
+
+func (f *fakeCommands) ExpireDue(now time.Time) ([]*models.Command, error) {
+	return nil, nil
+}
+
+// fakeShadows is a ShadowRepository that always returns an empty shadow.
+type fakeShadows struct{}
+
+func (fakeShadows) FindByDeviceID(deviceID string) (*models.DeviceShadow, error) {
+	return &models.DeviceShadow{DeviceID: deviceID}, nil
+}
+
+func (fakeShadows) SaveDesired(deviceID string, expectedVersion int64, desired map[string]interface{}) (*models.DeviceShadow, error) {
+	return &models.DeviceShadow{DeviceID: deviceID, Desired: desired, DesiredVersion: expectedVersion + 1}, nil
+}
+
+func (fakeShadows) SaveReported(deviceID string, expectedVersion int64, reported map[string]interface{}) (*models.DeviceShadow, error) {
+	return &models.DeviceShadow{DeviceID: deviceID, Reported: reported, ReportedVersion: expectedVersion + 1}, nil
+}
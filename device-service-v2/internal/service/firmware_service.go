@@ -0,0 +1,374 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+	"github.com/DXR3IN/device-service-v2/internal/storage"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrFirmwareNotFound  = errors.New("firmware not found")
+	ErrFirmwareExists    = errors.New("firmware version already exists")
+	ErrFirmwareTooLarge  = errors.New("firmware image too large")
+	ErrChecksumMismatch  = errors.New("firmware checksum mismatch")
+	ErrCampaignNotFound  = errors.New("campaign not found")
+	ErrInvalidCampaign   = errors.New("invalid campaign")
+	ErrInvalidRollout    = errors.New("invalid rollout report")
+	ErrNoUpdateAvailable = errors.New("no update available")
+	ErrRolloutClosed     = errors.New("rollout closed")
+)
+
+// FirmwareUpdate is what a device gets back when it checks for an update.
+type FirmwareUpdate struct {
+	CampaignID string
+	Firmware   *models.Firmware
+}
+
+type FirmwareService struct {
+	firmware  repository.FirmwareRepository
+	campaigns repository.CampaignRepository
+	devices   repository.DeviceRepository
+	groups    repository.GroupRepository
+	store     storage.BlobStore
+	maxSize   int64
+}
+
+func NewFirmwareService(f repository.FirmwareRepository, c repository.CampaignRepository, devices repository.DeviceRepository, groups repository.GroupRepository, store storage.BlobStore, maxSize int64) *FirmwareService {
+	return &FirmwareService{firmware: f, campaigns: c, devices: devices, groups: groups, store: store, maxSize: maxSize}
+}
+
+// MaxSize is the largest firmware image UploadFirmware accepts, in bytes.
+func (s *FirmwareService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// UploadFirmware stores the image and its metadata. When expectedSHA256 is
+// given the upload is rejected unless the image matches it.
+func (s *FirmwareService) UploadFirmware(ownerID, version, hardwareModel, expectedSHA256, notes string, image io.Reader) (*models.Firmware, error) {
+	ex, err := s.firmware.FindByVersion(ownerID, hardwareModel, version)
+	if err != nil {
+		return nil, err
+	}
+	if ex != nil {
+		return nil, ErrFirmwareExists
+	}
+
+	id := uuid.New().String()
+	hash := sha256.New()
+	size, err := s.store.Put(blobKey(id), io.TeeReader(io.LimitReader(image, s.maxSize+1), hash))
+	if err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if size > s.maxSize {
+		s.store.Delete(blobKey(id))
+		return nil, ErrFirmwareTooLarge
+	}
+	if expectedSHA256 != "" && !strings.EqualFold(expectedSHA256, sum) {
+		s.store.Delete(blobKey(id))
+		return nil, ErrChecksumMismatch
+	}
+
+	f := &repository.Firmware{
+		ID:            id,
+		OwnerID:       ownerID,
+		Version:       version,
+		HardwareModel: hardwareModel,
+		SHA256:        sum,
+		SizeBytes:     size,
+		Notes:         notes,
+	}
+	if err := s.firmware.Create(f); err != nil {
+		s.store.Delete(blobKey(id))
+		return nil, err
+	}
+	return f.ToDomain(), nil
+}
+
+func (s *FirmwareService) ListFirmware(ownerID string) ([]*models.Firmware, error) {
+	return s.firmware.FindByOwnerID(ownerID)
+}
+
+func (s *FirmwareService) GetFirmware(ownerID, firmwareID string) (*models.Firmware, error) {
+	f, err := s.firmware.FindByID(firmwareID)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil || f.OwnerID != ownerID {
+		return nil, ErrFirmwareNotFound
+	}
+	return f, nil
+}
+
+// CreateCampaign rolls firmware out to the owner's devices of the matching
+// hardware model: all of them, the members of a group, or a stable
+// percentage picked by hashing campaign and device IDs.
+func (s *FirmwareService) CreateCampaign(ownerID, firmwareID, targetType, groupID string, percentage int) (*models.Campaign, error) {
+	f, err := s.GetFirmware(ownerID, firmwareID)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := s.devices.FindByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	for _, d := range devices {
+		if d.HardwareModel == f.HardwareModel {
+			candidates = append(candidates, d.ID)
+		}
+	}
+
+	id := uuid.New().String()
+	switch targetType {
+	case models.CampaignTargetAll:
+		groupID, percentage = "", 0
+	case models.CampaignTargetGroup:
+		g, err := s.groups.FindByID(groupID)
+		if err != nil {
+			return nil, err
+		}
+		if g == nil || g.OwnerID != ownerID {
+			return nil, ErrGroupNotFound
+		}
+		members := make(map[string]struct{}, len(g.DeviceIDs))
+		for _, m := range g.DeviceIDs {
+			members[m] = struct{}{}
+		}
+		filtered := candidates[:0]
+		for _, c := range candidates {
+			if _, ok := members[c]; ok {
+				filtered = append(filtered, c)
+			}
+		}
+		candidates, percentage = filtered, 0
+	case models.CampaignTargetPercentage:
+		if percentage < 1 || percentage > 100 {
+			return nil, fmt.Errorf("%w: percentage must be between 1 and 100", ErrInvalidCampaign)
+		}
+		candidates, groupID = pickPercentage(id, candidates, percentage), ""
+	default:
+		return nil, fmt.Errorf("%w: target must be all, group or percentage", ErrInvalidCampaign)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no %s devices match the target", ErrInvalidCampaign, f.HardwareModel)
+	}
+
+	c := &repository.Campaign{
+		ID:         id,
+		OwnerID:    ownerID,
+		FirmwareID: f.ID,
+		TargetType: targetType,
+		GroupID:    groupID,
+		Percentage: percentage,
+		Status:     models.CampaignActive,
+	}
+	if err := s.campaigns.Create(c, candidates); err != nil {
+		return nil, err
+	}
+	campaign := c.ToDomain()
+	campaign.Summary = map[string]int{models.RolloutPending: len(candidates)}
+	return campaign, nil
+}
+
+func (s *FirmwareService) ListCampaigns(ownerID string) ([]*models.Campaign, error) {
+	return s.campaigns.FindByOwnerID(ownerID)
+}
+
+// GetCampaign returns the campaign with its per-status summary and the
+// rollout state of every targeted device.
+func (s *FirmwareService) GetCampaign(ownerID, campaignID string) (*models.Campaign, []*models.CampaignDevice, error) {
+	c, err := s.ownedCampaign(ownerID, campaignID)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary, err := s.campaigns.Summarize(campaignID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Summary = summary
+	devices, err := s.campaigns.FindDevices(campaignID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, devices, nil
+}
+
+func (s *FirmwareService) CancelCampaign(ownerID, campaignID string) (*models.Campaign, error) {
+	c, err := s.ownedCampaign(ownerID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != models.CampaignActive {
+		return nil, fmt.Errorf("%w: campaign is already %s", ErrInvalidCampaign, c.Status)
+	}
+	if err := s.campaigns.SetStatus(campaignID, models.CampaignCancelled); err != nil {
+		return nil, err
+	}
+	c.Status = models.CampaignCancelled
+	return c, nil
+}
+
+// CheckForUpdate tells a device which image to install, if any. A device
+// that already runs the campaign version is marked as succeeded right away.
+func (s *FirmwareService) CheckForUpdate(deviceID string) (*FirmwareUpdate, error) {
+	d, err := s.devices.FindByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
+	c, _, err := s.campaigns.FindOpenForDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrNoUpdateAvailable
+	}
+	f, err := s.firmware.FindByID(c.FirmwareID)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrNoUpdateAvailable
+	}
+	if d.FirmwareVersion == f.Version {
+		if _, err := s.ReportProgress(deviceID, c.ID, models.RolloutSucceeded, 100, ""); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoUpdateAvailable
+	}
+	return &FirmwareUpdate{CampaignID: c.ID, Firmware: f}, nil
+}
+
+// OpenImage returns the firmware image for a device that is part of an open
+// campaign for that firmware.
+func (s *FirmwareService) OpenImage(deviceID, firmwareID string) (*models.Firmware, io.ReadCloser, error) {
+	c, _, err := s.campaigns.FindOpenForDevice(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c == nil || c.FirmwareID != firmwareID {
+		return nil, nil, ErrFirmwareNotFound
+	}
+	f, err := s.firmware.FindByID(firmwareID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f == nil {
+		return nil, nil, ErrFirmwareNotFound
+	}
+	rc, err := s.store.Open(blobKey(f.ID))
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil, nil, ErrFirmwareNotFound
+		}
+		return nil, nil, err
+	}
+	return f, rc, nil
+}
+
+// ReportProgress records a device's rollout state. On success the device's
+// firmware_version is updated to the campaign version. Reports for a campaign
+// that is no longer active, or after the device's succeeded or failed
+// report, are rejected with ErrRolloutClosed.
+func (s *FirmwareService) ReportProgress(deviceID, campaignID, status string, progress int, errMsg string) (*models.CampaignDevice, error) {
+	if !models.IsValidRolloutReport(status) {
+		return nil, fmt.Errorf("%w: status must be downloading, installing, succeeded or failed", ErrInvalidRollout)
+	}
+	if progress < 0 || progress > 100 {
+		return nil, fmt.Errorf("%w: progress must be between 0 and 100", ErrInvalidRollout)
+	}
+	if status == models.RolloutSucceeded {
+		progress = 100
+	}
+
+	c, err := s.campaigns.FindByID(campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrCampaignNotFound
+	}
+	if c.Status != models.CampaignActive {
+		return nil, ErrRolloutClosed
+	}
+	// The update re-checks both conditions, so a report racing with a
+	// cancel or a final report changes nothing.
+	row, updated, err := s.campaigns.UpdateDeviceProgress(campaignID, deviceID, status, progress, errMsg)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, ErrCampaignNotFound
+	}
+	if !updated {
+		return nil, ErrRolloutClosed
+	}
+
+	if status == models.RolloutSucceeded {
+		f, err := s.firmware.FindByID(c.FirmwareID)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			if err := s.devices.UpdateFirmwareVersion(deviceID, f.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if models.IsRolloutFinal(status) {
+		if err := s.campaigns.CompleteIfFinished(campaignID); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+func (s *FirmwareService) ownedCampaign(ownerID, campaignID string) (*models.Campaign, error) {
+	c, err := s.campaigns.FindByID(campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.OwnerID != ownerID {
+		return nil, ErrCampaignNotFound
+	}
+	return c, nil
+}
+
+// pickPercentage deterministically selects percent of the devices, rounding
+// up so that a non-empty fleet always gets at least one device.
+func pickPercentage(seed string, deviceIDs []string, percent int) []string {
+	type ranked struct {
+		id   string
+		rank string
+	}
+	all := make([]ranked, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		sum := sha256.Sum256([]byte(seed + ":" + id))
+		all = append(all, ranked{id: id, rank: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].rank < all[j].rank })
+
+	n := (len(all)*percent + 99) / 100
+	picked := make([]string, 0, n)
+	for _, r := range all[:n] {
+		picked = append(picked, r.id)
+	}
+	return picked
+}
+
+func blobKey(firmwareID string) string {
+	return firmwareID + ".bin"
+}
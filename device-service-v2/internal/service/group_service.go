@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+)
+
+type GroupService struct {
+	repo repository.GroupRepository
+	auth deviceAuthorizer
+}
+
+func NewGroupService(r repository.GroupRepository, devices repository.DeviceRepository) *GroupService {
+	return &GroupService{repo: r, auth: deviceAuthorizer{devices: devices}}
+}
+
+func (s *GroupService) CreateGroup(ownerID, name, description string) (*models.DeviceGroup, error) {
+	if err := s.ensureNameFree(ownerID, name, ""); err != nil {
+		return nil, err
+	}
+	g := &repository.DeviceGroup{ID: uuid.New().String(), OwnerID: ownerID, Name: name, Description: description}
+	if err := s.repo.Create(g); err != nil {
+		return nil, err
+	}
+	return g.ToDomain(nil), nil
+}
+
+func (s *GroupService) ListGroups(ownerID string) ([]*models.DeviceGroup, error) {
+	return s.repo.FindByOwnerID(ownerID)
+}
+
+// GetGroup returns the group only when it belongs to ownerID.
+func (s *GroupService) GetGroup(ownerID, groupID string) (*models.DeviceGroup, error) {
+	g, err := s.repo.FindByID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil || g.OwnerID != ownerID {
+		return nil, ErrGroupNotFound
+	}
+	return g, nil
+}
+
+func (s *GroupService) UpdateGroup(ownerID, groupID, name, description string) (*models.DeviceGroup, error) {
+	if _, err := s.GetGroup(ownerID, groupID); err != nil {
+		return nil, err
+	}
+	if err := s.ensureNameFree(ownerID, name, groupID); err != nil {
+		return nil, err
+	}
+	g, err := s.repo.Update(groupID, name, description)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, ErrGroupNotFound
+	}
+	return g, nil
+}
+
+func (s *GroupService) DeleteGroup(ownerID, groupID string) error {
+	if _, err := s.GetGroup(ownerID, groupID); err != nil {
+		return err
+	}
+	return s.repo.Delete(groupID)
+}
+
+// AddDevices puts the given devices into the group. Every device must belong
+// to the group owner.
+func (s *GroupService) AddDevices(ownerID, groupID string, deviceIDs []string) (*models.DeviceGroup, error) {
+	if _, err := s.GetGroup(ownerID, groupID); err != nil {
+		return nil, err
+	}
+	if err := s.auth.devicesOf(ownerID, deviceIDs); err != nil {
+		return nil, err
+	}
+	if err := s.repo.AddDevices(groupID, deviceIDs); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByID(groupID)
+}
+
+func (s *GroupService) RemoveDevice(ownerID, groupID, deviceID string) (*models.DeviceGroup, error) {
+	if _, err := s.GetGroup(ownerID, groupID); err != nil {
+		return nil, err
+	}
+	if err := s.repo.RemoveDevice(groupID, deviceID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByID(groupID)
+}
+
+func (s *GroupService) ensureNameFree(ownerID, name, exceptID string) error {
+	groups, err := s.repo.FindByOwnerID(ownerID)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if g.Name == name && g.ID != exceptID {
+			return ErrGroupExists
+		}
+	}
+	return nil
+}
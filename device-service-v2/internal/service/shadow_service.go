@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+	"github.com/DXR3IN/device-service-v2/internal/repository"
+)
+
+// reportRetries bounds how often a device report is re-merged when it races
+// with another report.
+const reportRetries = 3
+
+var (
+	ErrInvalidShadow  = errors.New("invalid shadow document")
+	ErrShadowConflict = errors.New("shadow version conflict")
+)
+
+type ShadowService struct {
+	repo    repository.ShadowRepository
+	devices repository.DeviceRepository
+	auth    deviceAuthorizer
+	Broker  *Broker
+}
+
+func NewShadowService(r repository.ShadowRepository, devices repository.DeviceRepository, broker *Broker) *ShadowService {
+	return &ShadowService{repo: r, devices: devices, auth: deviceAuthorizer{devices: devices}, Broker: broker}
+}
+
+func (s *ShadowService) GetShadow(ownerID, deviceID string) (*models.DeviceShadow, error) {
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByDeviceID(deviceID)
+}
+
+// UpdateDesired merges patch into the desired section. When version is set
+// the update only applies if the desired section is still at that version.
+func (s *ShadowService) UpdateDesired(ownerID, deviceID string, patch map[string]interface{}, version *int64) (*models.DeviceShadow, error) {
+	d, err := s.auth.device(ownerID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.repo.FindByDeviceID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil && *version != current.DesiredVersion {
+		return nil, ErrShadowConflict
+	}
+
+	desired := models.MergeShadow(current.Desired, patch)
+	if err := models.ValidateShadow(desired); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShadow, err)
+	}
+	shadow, err := s.repo.SaveDesired(deviceID, current.DesiredVersion, desired)
+	if err != nil {
+		if errors.Is(err, repository.ErrShadowVersionConflict) {
+			return nil, ErrShadowConflict
+		}
+		return nil, err
+	}
+	s.publish(d.OwnerID, shadow)
+	return shadow, nil
+}
+
+// GetDelta is what a device polls: the desired entries it has not applied.
+func (s *ShadowService) GetDelta(deviceID string) (*models.DeviceShadow, error) {
+	if _, err := s.device(deviceID); err != nil {
+		return nil, err
+	}
+	return s.repo.FindByDeviceID(deviceID)
+}
+
+// ReportState merges what the device says it is running into the reported
+// section.
+func (s *ShadowService) ReportState(deviceID string, patch map[string]interface{}) (*models.DeviceShadow, error) {
+	d, err := s.device(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		current, err := s.repo.FindByDeviceID(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		reported := models.MergeShadow(current.Reported, patch)
+		shadow, err := s.repo.SaveReported(deviceID, current.ReportedVersion, reported)
+		if errors.Is(err, repository.ErrShadowVersionConflict) && attempt < reportRetries {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, repository.ErrShadowVersionConflict) {
+				return nil, ErrShadowConflict
+			}
+			return nil, err
+		}
+		s.publish(d.OwnerID, shadow)
+		return shadow, nil
+	}
+}
+
+func (s *ShadowService) device(deviceID string) (*models.Device, error) {
+	d, err := s.devices.FindByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+func (s *ShadowService) publish(ownerID string, shadow *models.DeviceShadow) {
+	if s.Broker == nil {
+		return
+	}
+	s.Broker.Publish(&Event{
+		Name:     EventShadow,
+		OwnerID:  ownerID,
+		DeviceID: shadow.DeviceID,
+		Data: &models.ShadowNotification{
+			DeviceID:        shadow.DeviceID,
+			OwnerID:         ownerID,
+			DesiredVersion:  shadow.DesiredVersion,
+			ReportedVersion: shadow.ReportedVersion,
+			Delta:           shadow.Delta,
+			InSync:          shadow.InSync,
+			UpdatedAt:       time.Now(),
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/device-service-v2/internal/domain"
+)
+
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+func (s *DeviceService) GetStatusHistory(ownerID, deviceID string, from, to time.Time) ([]*models.DeviceStatusEvent, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidTimeRange
+	}
+	if _, err := s.auth.device(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	return s.events.FindByDeviceID(deviceID, from, to)
+}
+
+// GetUptime summarises availability over [from, to). The status in effect at
+// the start of the window comes from the last transition before it; a device
+// with no transitions at all is assumed to have kept its current status.
+func (s *DeviceService) GetUptime(ownerID, deviceID string, from, to time.Time) (*models.DeviceUptime, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidTimeRange
+	}
+	d, err := s.auth.device(ownerID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	if created := d.CreatedAt; from.Before(created) {
+		from = created
+	}
+	uptime := &models.DeviceUptime{DeviceID: deviceID, From: from, To: to}
+	if !from.Before(to) {
+		return uptime, nil
+	}
+
+	events, err := s.events.FindByDeviceID(deviceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := s.events.FindLastBefore(deviceID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	current := d.Status
+	switch {
+	case prev != nil:
+		current = prev.ToStatus
+	case len(events) > 0:
+		current = events[0].FromStatus
+	}
+
+	var online, longest time.Duration
+	var outageStart time.Time
+	inOutage := current != models.StatusOnline
+	if inOutage {
+		outageStart = from
+		uptime.OutageCount++
+	}
+	cursor := from
+
+	for _, e := range events {
+		if current == models.StatusOnline {
+			online += e.CreatedAt.Sub(cursor)
+		}
+		cursor = e.CreatedAt
+		current = e.ToStatus
+
+		switch {
+		case inOutage && current == models.StatusOnline:
+			if gap := cursor.Sub(outageStart); gap > longest {
+				longest = gap
+			}
+			inOutage = false
+		case !inOutage && current != models.StatusOnline:
+			outageStart = cursor
+			inOutage = true
+			uptime.OutageCount++
+		}
+	}
+
+	if current == models.StatusOnline {
+		online += to.Sub(cursor)
+	}
+	if inOutage {
+		if gap := to.Sub(outageStart); gap > longest {
+			longest = gap
+		}
+	}
+
+	uptime.OnlineSeconds = online.Seconds()
+	uptime.LongestOutageSeconds = longest.Seconds()
+	uptime.UptimePercent = 100 * online.Seconds() / to.Sub(from).Seconds()
+	return uptime, nil
+}
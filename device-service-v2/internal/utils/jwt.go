@@ -8,6 +8,10 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// StreamTicketAudience marks the short-lived stream tickets issued by
+// auth-service. They are only accepted by VerifyStreamTicket.
+const StreamTicketAudience = "stream"
+
 type JWTManager struct {
 	secret string
 	ttl    time.Duration
@@ -26,7 +30,31 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// Verify checks a bearer token. Stream tickets are rejected.
 func (j *JWTManager) Verify(tokenStr string) (*Claims, error) {
+	claims, err := j.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if isStreamTicket(claims) {
+		return nil, errors.New("stream ticket used as bearer token")
+	}
+	return claims, nil
+}
+
+// VerifyStreamTicket accepts only stream tickets.
+func (j *JWTManager) VerifyStreamTicket(ticket string) (*Claims, error) {
+	claims, err := j.parse(ticket)
+	if err != nil {
+		return nil, err
+	}
+	if !isStreamTicket(claims) {
+		return nil, errors.New("not a stream ticket")
+	}
+	return claims, nil
+}
+
+func (j *JWTManager) parse(tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -42,3 +70,12 @@ func (j *JWTManager) Verify(tokenStr string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+func isStreamTicket(claims *Claims) bool {
+	for _, aud := range claims.Audience {
+		if aud == StreamTicketAudience {
+			return true
+		}
+	}
+	return false
+}
@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
+	// Aggregation time zones must resolve even without system tzdata.
+	_ "time/tzdata"
 
 	"github.com/DXR3IN/telemetry-service-v2/internal/config"
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
 	"github.com/DXR3IN/telemetry-service-v2/internal/http"
+	"github.com/DXR3IN/telemetry-service-v2/internal/pubsub"
 	repo "github.com/DXR3IN/telemetry-service-v2/internal/repository"
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/DXR3IN/telemetry-service-v2/internal/storage"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -28,13 +35,91 @@ func main() {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&repo.Telemetry{}); err != nil {
+	// Readings stored before the rollup tables existed are rolled up once.
+	backfillRollups := !db.Migrator().HasTable(&repo.TelemetryRollupHourly{})
+
+	if err := db.AutoMigrate(
+		&repo.TelemetryDedupKey{},
+		&repo.TelemetryArchive{},
+		&repo.QuarantinedTelemetry{},
+		&repo.DeviceIngestStat{},
+		&repo.Metric{},
+		&repo.TelemetryRollupHourly{},
+		&repo.TelemetryRollupDaily{},
+		&repo.TelemetryRollupDirty{},
+		&repo.TelemetryRetentionPolicy{},
+	); err != nil {
 		log.Fatalf("failed to migrate: %v", err)
 	}
+	if cfg.PartitionMonthsAhead < 1 {
+		log.Fatalf("PARTITION_MONTHS_AHEAD must be at least 1")
+	}
+	// telemetries is partitioned by month, which AutoMigrate cannot do.
+	if err := repo.MigrateTelemetryTable(db, time.Now().Add(-cfg.MaxClockBehind), cfg.PartitionMonthsAhead); err != nil {
+		log.Fatalf("failed to migrate telemetries: %v", err)
+	}
+
+	rollupRepo := repo.NewRollupRepository(db)
+	if backfillRollups {
+		if err := rollupRepo.MarkAllDirty(); err != nil {
+			log.Fatalf("failed to queue rollup backfill: %v", err)
+		}
+	}
+
+	metricRepo := repo.NewMetricRepository(db)
+	if err := metricRepo.EnsureDefaults(models.DefaultMetrics()); err != nil {
+		log.Fatalf("failed to seed metric catalogue: %v", err)
+	}
+
+	if !service.IsValidSlowConsumerPolicy(cfg.SlowConsumerPolicy) {
+		log.Fatalf("unknown STREAM_SLOW_CONSUMER_POLICY %q", cfg.SlowConsumerPolicy)
+	}
 
-	telemetryRepo := repo.NewTelemetryRepository(db)
+	if !service.IsValidDeletePolicy(cfg.DeletePolicy) {
+		log.Fatalf("unknown TELEMETRY_DELETE_POLICY %q", cfg.DeletePolicy)
+	}
+
+	if err := cfg.Retention.Validate(); err != nil {
+		log.Fatalf("invalid RETENTION_* policy: %v", err)
+	}
+	if cfg.RetentionBatchSize <= 0 {
+		log.Fatalf("RETENTION_BATCH_SIZE must be positive")
+	}
+	var archive storage.ArchiveStore
+	if cfg.RetentionArchiveDir != "" {
+		local, err := storage.NewLocalArchiveStore(cfg.RetentionArchiveDir)
+		if err != nil {
+			log.Fatalf("failed to open retention archive: %v", err)
+		}
+		archive = local
+	}
+
+	// Postgres LISTEN/NOTIFY lets several replicas share stream events.
+	var backend service.BrokerBackend
+	switch cfg.BrokerBackend {
+	case "memory":
+	case "postgres":
+		pg, err := pubsub.NewPostgres(db, dsn, "telemetry_stream_events")
+		if err != nil {
+			log.Fatalf("failed to start broker backend: %v", err)
+		}
+		backend = pg
+	default:
+		log.Fatalf("unknown BROKER_BACKEND %q", cfg.BrokerBackend)
+	}
+
+	repos := http.Repositories{
+		Telemetry:     repo.NewTelemetryRepository(db),
+		Rollups:       rollupRepo,
+		Retention:     repo.NewRetentionRepository(db),
+		Partitions:    repo.NewPartitionRepository(db),
+		Archive:       archive,
+		Ingest:        repo.NewIngestRepository(db),
+		Metrics:       metricRepo,
+		BrokerBackend: backend,
+	}
 
-	r := http.NewRouter(cfg, telemetryRepo)
+	r := http.NewRouter(cfg, repos)
 
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Starting server at %s", addr)
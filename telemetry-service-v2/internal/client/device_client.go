@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+// DeviceClient talks to device-service-v2. Calls made on behalf of a user
+// forward that user's Authorization header, so device-service-v2 applies its
+// own ownership rules; internal calls use the shared INTERNAL_API_KEY.
+type DeviceClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func NewDeviceClient(baseURL, apiKey string) *DeviceClient {
+	return &DeviceClient{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// DeviceOwner returns the ID of the user who owns deviceID.
+func (c *DeviceClient) DeviceOwner(deviceID string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/internal/devices/"+url.PathEscape(deviceID)+"/owner", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Internal-Key", c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrDeviceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("device service: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			OwnerID string `json:"owner_id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Data.OwnerID, nil
+}
+
+// GroupDeviceIDs returns the IDs of the devices in groupID as seen by the
+// user behind authHeader.
+func (c *DeviceClient) GroupDeviceIDs(authHeader, groupID string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/groups/"+url.PathEscape(groupID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrGroupNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device service: unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			DeviceIDs []string `json:"device_ids"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data.DeviceIDs, nil
+}
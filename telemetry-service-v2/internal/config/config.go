@@ -2,6 +2,10 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
 )
 
 type Config struct {
@@ -12,6 +16,35 @@ type Config struct {
 	DBPass    string
 	DBName    string
 	JWTSecret string
+
+	DeviceServiceURL  string
+	BrokerBackend     string
+	InternalAPIKey    string
+	OwnershipCacheTTL time.Duration
+
+	MetricRefreshInterval time.Duration
+
+	BatchMaxSize   int
+	MaxClockAhead  time.Duration
+	MaxClockBehind time.Duration
+
+	RollupInterval time.Duration
+
+	Retention           models.RetentionPolicy
+	RetentionInterval   time.Duration
+	RetentionBatchSize  int
+	RetentionArchiveDir string
+
+	QuarantineDays    int
+	QuarantineMaxRows int
+
+	PartitionMonthsAhead int
+	PartitionInterval    time.Duration
+
+	StreamBufferSize   int
+	SlowConsumerPolicy string
+
+	DeletePolicy string
 }
 
 func NewConfigFromEnv() *Config {
@@ -24,6 +57,39 @@ func NewConfigFromEnv() *Config {
 		DBPass:    getEnv("DB_PASS", "postgres"),
 		DBName:    getEnv("DB_NAME", "authdb"),
 		JWTSecret: getEnv("JWT_SECRET", "secret"),
+
+		DeviceServiceURL:  getEnv("DEVICE_SERVICE_URL", "http://device-service-v2:8082"),
+		BrokerBackend:     getEnv("BROKER_BACKEND", "memory"),
+		InternalAPIKey:    getEnv("INTERNAL_API_KEY", ""),
+		OwnershipCacheTTL: getDuration("OWNERSHIP_CACHE_TTL", time.Minute),
+
+		MetricRefreshInterval: getDuration("METRIC_REFRESH_INTERVAL", time.Minute),
+
+		BatchMaxSize:   getInt("TELEMETRY_BATCH_MAX_SIZE", 500),
+		MaxClockAhead:  getDuration("TELEMETRY_MAX_CLOCK_AHEAD", 5*time.Minute),
+		MaxClockBehind: getDuration("TELEMETRY_MAX_CLOCK_BEHIND", 7*24*time.Hour),
+
+		RollupInterval: getDuration("ROLLUP_INTERVAL", time.Minute),
+
+		Retention: models.RetentionPolicy{
+			RawDays:    getInt("RETENTION_RAW_DAYS", 0),
+			HourlyDays: getInt("RETENTION_HOURLY_DAYS", 0),
+			DailyDays:  getInt("RETENTION_DAILY_DAYS", 0),
+		},
+		RetentionInterval:   getDuration("RETENTION_INTERVAL", time.Hour),
+		RetentionBatchSize:  getInt("RETENTION_BATCH_SIZE", 5000),
+		RetentionArchiveDir: getEnv("RETENTION_ARCHIVE_DIR", ""),
+
+		QuarantineDays:    getInt("QUARANTINE_RETENTION_DAYS", 30),
+		QuarantineMaxRows: getInt("QUARANTINE_MAX_ROWS", 100000),
+
+		PartitionMonthsAhead: getInt("PARTITION_MONTHS_AHEAD", 3),
+		PartitionInterval:    getDuration("PARTITION_MAINTENANCE_INTERVAL", 24*time.Hour),
+
+		StreamBufferSize:   getInt("STREAM_SUBSCRIBER_BUFFER", 64),
+		SlowConsumerPolicy: getEnv("STREAM_SLOW_CONSUMER_POLICY", "drop_oldest"),
+
+		DeletePolicy: getEnv("TELEMETRY_DELETE_POLICY", "purge"),
 	}
 }
 
@@ -33,3 +99,21 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Functions of the aggregation API. Percentiles are written pNN, e.g. p95.
+const (
+	AggregateAvg    = "avg"
+	AggregateMin    = "min"
+	AggregateMax    = "max"
+	AggregateLast   = "last"
+	AggregateCount  = "count"
+	AggregateStddev = "stddev"
+)
+
+// Resolutions the aggregation API reads from: the raw readings or the
+// precomputed UTC hourly and daily rollups.
+const (
+	ResolutionRaw    = "raw"
+	ResolutionHourly = "hourly"
+	ResolutionDaily  = "daily"
+)
+
+// AggregateQuery asks for one device's readings summarized per time bucket.
+// Buckets are aligned to midnight (and Mondays for multiples of a week) in
+// Location.
+type AggregateQuery struct {
+	DeviceID  string
+	From      time.Time
+	To        time.Time
+	Bucket    time.Duration
+	Location  *time.Location
+	Metrics   []string
+	Functions []string
+}
+
+// Percentile returns the fraction a pNN function stands for.
+func Percentile(fn string) (float64, bool) {
+	if len(fn) < 2 || fn[0] != 'p' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fn[1:])
+	if err != nil || n < 1 || n > 99 || strconv.Itoa(n) != fn[1:] {
+		return 0, false
+	}
+	return float64(n) / 100, true
+}
+
+func IsValidAggregateFunction(fn string) bool {
+	switch fn {
+	case AggregateAvg, AggregateMin, AggregateMax, AggregateLast, AggregateCount, AggregateStddev:
+		return true
+	}
+	_, ok := Percentile(fn)
+	return ok
+}
+
+// AggregateBucket holds the results of one bucket as
+// Values[metric][function]. A value is null when the bucket has readings but
+// none for that metric.
+type AggregateBucket struct {
+	Start  time.Time                      `json:"start"`
+	Values map[string]map[string]*float64 `json:"values"`
+}
+
+type AggregateResult struct {
+	DeviceID string    `json:"device_id"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Bucket   string    `json:"bucket"`
+	Timezone string    `json:"timezone"`
+	// Resolution is the data the buckets were computed from.
+	Resolution string            `json:"resolution"`
+	Buckets    []AggregateBucket `json:"buckets"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Orders of the history API.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// HistoryQuery asks for one page of a device's raw readings created in
+// [From, To). After continues a previous page.
+type HistoryQuery struct {
+	DeviceID string
+	From     time.Time
+	To       time.Time
+	Order    string
+	Limit    int
+	After    *HistoryCursor
+}
+
+// HistoryCursor is the position after the last reading of a page, together
+// with the window and order of the query it came from so that following
+// pages stay in the same window.
+type HistoryCursor struct {
+	From      time.Time `json:"f"`
+	To        time.Time `json:"t"`
+	Order     string    `json:"o"`
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c *HistoryCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeHistoryCursor(s string) (*HistoryCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c HistoryCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.ID == "" || (c.Order != OrderAsc && c.Order != OrderDesc) {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &c, nil
+}
+
+type Paging struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Order      string    `json:"order"`
+	Limit      int       `json:"limit"`
+	Count      int       `json:"count"`
+	HasMore    bool      `json:"has_more"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type TelemetryPage struct {
+	Telemetries []*Telemetry `json:"telemetries"`
+	Paging      Paging       `json:"paging"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Reasons a reading is quarantined instead of stored.
+const (
+	RejectUnknownDevice       = "unknown_device"
+	RejectRegistryUnavailable = "registry_unavailable"
+	RejectUnknownMetric       = "unknown_metric"
+	RejectOutOfRange          = "out_of_range"
+	RejectClockSkew           = "clock_skew"
+)
+
+// Outcomes of one reading in a batch upload.
+const (
+	BatchItemAccepted  = "accepted"
+	BatchItemDuplicate = "duplicate"
+	BatchItemRejected  = "rejected"
+)
+
+// TelemetryReading is one entry of a batch upload. Timestamp is when the
+// device took the sample; without it the time the batch arrived is used.
+type TelemetryReading struct {
+	Telemetry
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// BatchItemResult reports what happened to one reading, identified by its
+// position in the request.
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	Accepted   int               `json:"accepted"`
+	Duplicates int               `json:"duplicates"`
+	Rejected   int               `json:"rejected"`
+	Results    []BatchItemResult `json:"results"`
+}
+
+// QuarantinedTelemetry is a rejected reading kept for inspection.
+type QuarantinedTelemetry struct {
+	ID         string          `json:"id"`
+	DeviceID   string          `json:"device_id"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// IngestStats counts what one device has sent.
+type IngestStats struct {
+	DeviceID         string     `json:"device_id"`
+	Accepted         int64      `json:"accepted"`
+	Rejected         int64      `json:"rejected"`
+	LastAcceptedAt   *time.Time `json:"last_accepted_at,omitempty"`
+	LastRejectedAt   *time.Time `json:"last_rejected_at,omitempty"`
+	LastRejectReason string     `json:"last_reject_reason,omitempty"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"math"
+	"regexp"
+	"time"
+)
+
+// Metric describes one kind of sensor value that devices may report. Min and
+// Max bound accepted values when set; Precision is the number of decimals
+// kept.
+type Metric struct {
+	Name        string    `json:"name"`
+	Unit        string    `json:"unit"`
+	Description string    `json:"description,omitempty"`
+	Min         *float64  `json:"min,omitempty"`
+	Max         *float64  `json:"max,omitempty"`
+	Precision   *int      `json:"precision,omitempty"`
+	BuiltIn     bool      `json:"built_in"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// The metrics that have their own field on Telemetry.
+const (
+	MetricPpm                      = "ppm"
+	MetricWaterLevelOnPlant        = "water_level_on_plant"
+	MetricWaterLevelOnCondenser    = "water_level_on_condenser"
+	MetricWaterLevelOnNutrientTank = "water_level_on_nutrient_tank"
+	MetricHumidity                 = "humidity"
+)
+
+var metricNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func ValidMetricName(name string) bool {
+	return metricNamePattern.MatchString(name)
+}
+
+// IsFieldMetric reports whether name is one of the original Telemetry
+// fields, which are never sent inside "metrics".
+func IsFieldMetric(name string) bool {
+	switch name {
+	case MetricPpm, MetricWaterLevelOnPlant, MetricWaterLevelOnCondenser, MetricWaterLevelOnNutrientTank, MetricHumidity:
+		return true
+	}
+	return false
+}
+
+// Check returns false when v falls outside the metric's range.
+func (m *Metric) Check(v float64) bool {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return false
+	}
+	if m.Min != nil && v < *m.Min {
+		return false
+	}
+	if m.Max != nil && v > *m.Max {
+		return false
+	}
+	return true
+}
+
+// Round applies the metric's precision to v.
+func (m *Metric) Round(v float64) float64 {
+	if m.Precision == nil {
+		return v
+	}
+	p := math.Pow(10, float64(*m.Precision))
+	return math.Round(v*p) / p
+}
+
+// DefaultMetrics is the catalogue every installation starts with. The
+// original fields carry no range so existing devices keep working.
+func DefaultMetrics() []*Metric {
+	r := func(v float64) *float64 { return &v }
+	p := func(v int) *int { return &v }
+	return []*Metric{
+		{Name: MetricPpm, Unit: "ppm", Description: "Nutrient concentration (TDS)"},
+		{Name: MetricWaterLevelOnPlant, Unit: "", Description: "Water level at the plant channel"},
+		{Name: MetricWaterLevelOnCondenser, Unit: "", Description: "Water level in the condenser"},
+		{Name: MetricWaterLevelOnNutrientTank, Unit: "", Description: "Water level in the nutrient tank"},
+		{Name: MetricHumidity, Unit: "%", Description: "Relative air humidity"},
+		{Name: "ph", Unit: "pH", Description: "Nutrient solution acidity", Min: r(0), Max: r(14), Precision: p(2)},
+		{Name: "ec", Unit: "mS/cm", Description: "Electrical conductivity", Min: r(0), Max: r(20), Precision: p(2)},
+		{Name: "water_temperature", Unit: "°C", Description: "Nutrient solution temperature", Min: r(-10), Max: r(60), Precision: p(1)},
+		{Name: "air_temperature", Unit: "°C", Description: "Air temperature", Min: r(-40), Max: r(70), Precision: p(1)},
+		{Name: "lux", Unit: "lx", Description: "Illuminance", Min: r(0), Max: r(200000), Precision: p(0)},
+		{Name: "co2", Unit: "ppm", Description: "CO2 concentration", Min: r(0), Max: r(10000), Precision: p(0)},
+	}
+}
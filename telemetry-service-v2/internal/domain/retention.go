@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// RetentionPolicy says for how many days each level of telemetry is kept; 0
+// keeps it forever. Hourly rollups are computed from raw readings and daily
+// ones from hourly rollups, so a coarser level has to be kept at least as
+// long as the finer one.
+type RetentionPolicy struct {
+	RawDays    int `json:"raw_days"`
+	HourlyDays int `json:"hourly_days"`
+	DailyDays  int `json:"daily_days"`
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.RawDays < 0 || p.HourlyDays < 0 || p.DailyDays < 0 {
+		return errors.New("retention days must not be negative")
+	}
+	if !keepsAtLeast(p.HourlyDays, p.RawDays) {
+		return errors.New("hourly_days must be 0 or at least raw_days")
+	}
+	if !keepsAtLeast(p.DailyDays, p.HourlyDays) {
+		return errors.New("daily_days must be 0 or at least hourly_days")
+	}
+	return nil
+}
+
+// Days returns the retention of one resolution.
+func (p RetentionPolicy) Days(resolution string) int {
+	switch resolution {
+	case ResolutionHourly:
+		return p.HourlyDays
+	case ResolutionDaily:
+		return p.DailyDays
+	}
+	return p.RawDays
+}
+
+func keepsAtLeast(coarse, fine int) bool {
+	if coarse == 0 {
+		return true
+	}
+	return fine != 0 && coarse >= fine
+}
+
+// RetentionOverride replaces parts of the global policy for one device. Nil
+// fields inherit the global value.
+type RetentionOverride struct {
+	RawDays    *int `json:"raw_days"`
+	HourlyDays *int `json:"hourly_days"`
+	DailyDays  *int `json:"daily_days"`
+}
+
+func (o *RetentionOverride) Empty() bool {
+	return o == nil || (o.RawDays == nil && o.HourlyDays == nil && o.DailyDays == nil)
+}
+
+// Apply returns the policy of a device whose global policy is p.
+func (o *RetentionOverride) Apply(p RetentionPolicy) RetentionPolicy {
+	if o == nil {
+		return p
+	}
+	if o.RawDays != nil {
+		p.RawDays = *o.RawDays
+	}
+	if o.HourlyDays != nil {
+		p.HourlyDays = *o.HourlyDays
+	}
+	if o.DailyDays != nil {
+		p.DailyDays = *o.DailyDays
+	}
+	return p
+}
+
+// Overrides reports whether the override sets the retention of resolution.
+func (o *RetentionOverride) Overrides(resolution string) bool {
+	switch resolution {
+	case ResolutionHourly:
+		return o.HourlyDays != nil
+	case ResolutionDaily:
+		return o.DailyDays != nil
+	}
+	return o.RawDays != nil
+}
+
+// DeviceStorage is what one device currently occupies.
+type DeviceStorage struct {
+	RawReadings   int64      `json:"raw_readings"`
+	HourlyRollups int64      `json:"hourly_rollups"`
+	DailyRollups  int64      `json:"daily_rollups"`
+	OldestReading *time.Time `json:"oldest_reading,omitempty"`
+	NewestReading *time.Time `json:"newest_reading,omitempty"`
+}
+
+// DeviceRetention is the retention view of one device: the policy in
+// effect, the device's own override and its storage.
+type DeviceRetention struct {
+	DeviceID string             `json:"device_id"`
+	Policy   RetentionPolicy    `json:"policy"`
+	Override *RetentionOverride `json:"override,omitempty"`
+	Storage  *DeviceStorage     `json:"storage"`
+}
+
+type TableUsage struct {
+	Table         string `json:"table"`
+	Bytes         int64  `json:"bytes"`
+	EstimatedRows int64  `json:"estimated_rows"`
+}
+
+// TelemetryPartition is one partition of the raw telemetry table. From and
+// To bound a monthly partition; the default partition has neither.
+type TelemetryPartition struct {
+	Name          string     `json:"name"`
+	From          *time.Time `json:"from,omitempty"`
+	To            *time.Time `json:"to,omitempty"`
+	Bytes         int64      `json:"bytes"`
+	EstimatedRows int64      `json:"estimated_rows"`
+}
+
+// StorageUsage is the operator view of retention and disk use.
+type StorageUsage struct {
+	Policy          RetentionPolicy      `json:"policy"`
+	DeviceOverrides int64                `json:"device_overrides"`
+	Tables          []TableUsage         `json:"tables"`
+	Partitions      []TelemetryPartition `json:"partitions"`
+	ArchiveEnabled  bool                 `json:"archive_enabled"`
+	ArchiveFiles    int64                `json:"archive_files"`
+	ArchiveBytes    int64                `json:"archive_bytes"`
+}
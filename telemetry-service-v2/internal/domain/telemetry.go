@@ -11,4 +11,30 @@ type Telemetry struct {
 	WaterLevelOnNutrientTank float64   `json:"water_level_on_nutrient_tank"`
 	Humidity                 float64   `json:"humidity"`
 	CreatedAt                time.Time `json:"created_at"`
+	// Metrics carries catalogue metrics other than the fields above, keyed
+	// by metric name.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	// Sequence and IdempotencyKey let a device resend a reading without it
+	// being stored twice. Both are optional and unique per device.
+	Sequence       *int64 `json:"seq,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type MetricSummary struct {
+	Avg float64 `json:"avg"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// TelemetrySummary aggregates readings across several devices over a window.
+type TelemetrySummary struct {
+	DeviceCount              int64         `json:"device_count"`
+	SampleCount              int64         `json:"sample_count"`
+	From                     time.Time     `json:"from"`
+	To                       time.Time     `json:"to"`
+	Ppm                      MetricSummary `json:"ppm"`
+	WaterLevelOnPlant        MetricSummary `json:"water_level_on_plant"`
+	WaterLevelOnCondenser    MetricSummary `json:"water_level_on_condenser"`
+	WaterLevelOnNutrientTank MetricSummary `json:"water_level_on_nutrient_tank"`
+	Humidity                 MetricSummary `json:"humidity"`
 }
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *TelemetryHandler) AggregateTelemetry(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	bucket, err := parseBucket(c.DefaultQuery("bucket", "1h"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid bucket (examples: 5m, 1h, 1d, 7d)"})
+		return
+	}
+	// "Local" loads the server's zone, whose name Postgres does not know.
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+	if err != nil || loc == time.Local {
+		c.JSON(400, gin.H{"error": "invalid tz, expected an IANA time zone such as Asia/Jakarta"})
+		return
+	}
+	functions := queryList(c, "functions")
+	if len(functions) == 0 {
+		functions = []string{models.AggregateAvg}
+	}
+
+	data, err := h.svc.AggregateTelemetry(ownerID, &models.AggregateQuery{
+		DeviceID:  c.Param("device_id"),
+		From:      from,
+		To:        to,
+		Bucket:    bucket,
+		Location:  loc,
+		Metrics:   queryList(c, "metrics"),
+		Functions: functions,
+	})
+	if err != nil {
+		if err == service.ErrDeviceNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidAggregate) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate telemetry"})
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "telemetry aggregated", Data: data})
+}
+
+// parseTimeRange reads the RFC 3339 `from`/`to` query parameters. `to`
+// defaults to now and `from` to 24 hours before `to`.
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid to, expected RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid from, expected RFC 3339"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+	return from, to, true
+}
+
+// parseBucket accepts Go durations plus whole days such as "1d" or "7d".
+func parseBucket(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errors.New("invalid day count")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// queryList collects a comma-separated or repeated query parameter, without
+// duplicates.
+func queryList(c *gin.Context, key string) []string {
+	var list []string
+	seen := make(map[string]struct{})
+	for _, v := range c.QueryArray(key) {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if _, ok := seen[item]; ok {
+				continue
+			}
+			seen[item] = struct{}{}
+			list = append(list, item)
+		}
+	}
+	return list
+}
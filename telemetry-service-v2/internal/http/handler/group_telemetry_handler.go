@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *TelemetryHandler) GetTelemetryByGroupID(c *gin.Context) {
+	duration, ok := parseDuration(c)
+	if !ok {
+		return
+	}
+	data, err := h.svc.GetTelemetryByGroupID(c.GetHeader("Authorization"), c.Param("group_id"), duration)
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, getAllTelemetryResp{Telemetries: data})
+}
+
+func (h *TelemetryHandler) GetLatestTelemetryByGroupID(c *gin.Context) {
+	data, err := h.svc.GetLatestTelemetryByGroupID(c.GetHeader("Authorization"), c.Param("group_id"))
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, getAllTelemetryResp{Telemetries: data})
+}
+
+func (h *TelemetryHandler) SummarizeTelemetryByGroupID(c *gin.Context) {
+	duration, ok := parseDuration(c)
+	if !ok {
+		return
+	}
+	data, err := h.svc.SummarizeTelemetryByGroupID(c.GetHeader("Authorization"), c.Param("group_id"), duration)
+	if err != nil {
+		writeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "group summary", Data: data})
+}
+
+func parseDuration(c *gin.Context) (time.Duration, bool) {
+	duration, err := time.ParseDuration(c.DefaultQuery("duration", "1h"))
+	if err != nil || duration <= 0 {
+		c.JSON(400, gin.H{"error": "format durasi salah (contoh: 1h, 30m, 24h)"})
+		return 0, false
+	}
+	return duration, true
+}
+
+func writeGroupError(c *gin.Context, err error) {
+	if err == service.ErrGroupNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get group telemetry"})
+}
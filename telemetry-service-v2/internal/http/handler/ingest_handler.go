@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *TelemetryHandler) GetIngestStats(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	stats, err := h.svc.GetIngestStats(ownerID, c.Param("device_id"))
+	if err == service.ErrDeviceNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ingest stats"})
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "ingest stats", Data: stats})
+}
+
+func (h *TelemetryHandler) ListQuarantine(c *gin.Context) {
+	rows, err := h.svc.ListQuarantine(c.Query("device_id"), c.Query("reason"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list quarantine"})
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "quarantined telemetry", Data: rows})
+}
+
+func (h *TelemetryHandler) ListIngestStats(c *gin.Context) {
+	stats, err := h.svc.ListIngestStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ingest stats"})
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "ingest stats", Data: stats})
+}
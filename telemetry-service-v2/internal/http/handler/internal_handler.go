@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// InternalHandler serves calls from the other services.
+type InternalHandler struct {
+	cleanup *service.CleanupService
+}
+
+func NewInternalHandler(cleanup *service.CleanupService) *InternalHandler {
+	return &InternalHandler{cleanup: cleanup}
+}
+
+func (h *InternalHandler) DeviceDeleted(c *gin.Context) {
+	n, err := h.cleanup.DeviceDeleted(c.Param("device_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
+	}
+	response := responseWithMessage{
+		Message: "Device Telemetry Cleaned Up",
+		Data:    gin.H{"device_id": c.Param("device_id"), "readings": n},
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type MetricHandler struct {
+	svc *service.MetricService
+}
+
+func NewMetricHandler(svc *service.MetricService) *MetricHandler {
+	return &MetricHandler{svc: svc}
+}
+
+type metricRequest struct {
+	Name        string   `json:"name"`
+	Unit        string   `json:"unit"`
+	Description string   `json:"description"`
+	Min         *float64 `json:"min"`
+	Max         *float64 `json:"max"`
+	Precision   *int     `json:"precision"`
+}
+
+func (r *metricRequest) toDomain() *models.Metric {
+	return &models.Metric{
+		Name:        r.Name,
+		Unit:        r.Unit,
+		Description: r.Description,
+		Min:         r.Min,
+		Max:         r.Max,
+		Precision:   r.Precision,
+	}
+}
+
+func (h *MetricHandler) ListMetrics(c *gin.Context) {
+	metrics, err := h.svc.ListMetrics()
+	if err != nil {
+		writeMetricError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "metric catalogue", Data: metrics})
+}
+
+func (h *MetricHandler) GetMetric(c *gin.Context) {
+	metric, err := h.svc.GetMetric(c.Param("name"))
+	if err != nil {
+		writeMetricError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "metric found", Data: metric})
+}
+
+func (h *MetricHandler) CreateMetric(c *gin.Context) {
+	var req metricRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	metric, err := h.svc.CreateMetric(req.toDomain())
+	if err != nil {
+		writeMetricError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, responseWithMessage{Message: "Metric Created Successfully", Data: metric})
+}
+
+func (h *MetricHandler) UpdateMetric(c *gin.Context) {
+	var req metricRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = c.Param("name")
+	metric, err := h.svc.UpdateMetric(req.toDomain())
+	if err != nil {
+		writeMetricError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "Metric Updated Successfully", Data: metric})
+}
+
+func writeMetricError(c *gin.Context, err error) {
+	switch {
+	case err == service.ErrMetricNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "metric not found"})
+	case err == service.ErrMetricExists:
+		c.JSON(http.StatusConflict, gin.H{"error": "metric already exists"})
+	case errors.Is(err, service.ErrInvalidMetric):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
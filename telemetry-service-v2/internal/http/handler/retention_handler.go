@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	svc *service.RetentionService
+}
+
+func NewRetentionHandler(svc *service.RetentionService) *RetentionHandler {
+	return &RetentionHandler{svc: svc}
+}
+
+func (h *RetentionHandler) GetDeviceRetention(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	data, err := h.svc.GetDeviceRetention(ownerID, c.Param("device_id"))
+	if err != nil {
+		writeRetentionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "retention policy", Data: data})
+}
+
+func (h *RetentionHandler) SetDeviceRetention(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req models.RetentionOverride
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.svc.SetDeviceRetention(ownerID, c.Param("device_id"), &req)
+	if err != nil {
+		writeRetentionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "Retention Policy Updated Successfully", Data: data})
+}
+
+// StorageUsage shows the global policy and how much space telemetry takes.
+func (h *RetentionHandler) StorageUsage(c *gin.Context) {
+	data, err := h.svc.StorageUsage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, responseWithMessage{Message: "storage usage", Data: data})
+}
+
+func writeRetentionError(c *gin.Context, err error) {
+	switch {
+	case err == service.ErrDeviceNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+	case errors.Is(err, service.ErrInvalidRetention):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
@@ -26,24 +28,88 @@ type responseWithMessage struct {
 	Data    interface{} `json:"data"`
 }
 
+// GetTelemetryByDeviceID pages through a device's readings. The first page
+// takes `from`/`to` (or `duration` back from `to`), `order` and `limit`;
+// following pages pass the returned `cursor` and optionally `limit`.
 func (h *TelemetryHandler) GetTelemetryByDeviceID(c *gin.Context) {
-	deviceID := c.Param("device_id")
-	durationStr := c.DefaultQuery("duration", "1h") // default 1 jam jika kosong
-	duration, err := time.ParseDuration(durationStr)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "format durasi salah (contoh: 1h, 30m, 24h)"})
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	q := &models.HistoryQuery{
+		DeviceID: c.Param("device_id"),
+		Order:    c.DefaultQuery("order", models.OrderDesc),
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(400, gin.H{"error": "invalid limit, expected a positive number"})
+			return
+		}
+		q.Limit = n
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := models.DecodeHistoryCursor(v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid cursor"})
+			return
+		}
+		q.After = cursor
+	} else if !parseHistoryRange(c, q) {
 		return
 	}
-	data, err := h.svc.GetTelemetryByDeviceID(duration, deviceID)
+
+	data, err := h.svc.GetTelemetryByDeviceID(ownerID, q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the telemetry at: " + durationStr})
+		if err == service.ErrDeviceNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidHistory) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the telemetry"})
+		return
 	}
-	c.JSON(http.StatusOK, data)
+	c.JSON(http.StatusOK, responseWithMessage{Message: "Telemetry Found", Data: data})
+}
+
+// parseHistoryRange reads `from`/`to` like the aggregation API, except that
+// without `from` the window is `duration` (default 1h) back from `to`.
+func parseHistoryRange(c *gin.Context, q *models.HistoryQuery) bool {
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return false
+	}
+	if c.Query("from") == "" {
+		duration, err := time.ParseDuration(c.DefaultQuery("duration", "1h"))
+		if err != nil || duration <= 0 {
+			c.JSON(400, gin.H{"error": "format durasi salah (contoh: 1h, 30m, 24h)"})
+			return false
+		}
+		from = to.Add(-duration)
+	} else if c.Query("duration") != "" {
+		c.JSON(400, gin.H{"error": "use either from or duration, not both"})
+		return false
+	}
+	q.From, q.To = from, to
+	return true
 }
 
 func (h *TelemetryHandler) GetLatestTelemetry(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
 	deviceID := c.Param("device_id")
-	data, err := h.svc.GetLatestTelemetryByDeviceID(deviceID)
+	data, err := h.svc.GetLatestTelemetryByDeviceID(ownerID, deviceID)
+	if err == service.ErrDeviceNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get latest Telemetry"})
 		return
@@ -58,15 +124,35 @@ func (h *TelemetryHandler) InsertTelemetry(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	data, err := h.svc.InsertTelemetry(&req)
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	data, created, err := h.svc.InsertTelemetry(&req)
 	if err != nil {
-		// if err == service.ErrDeviceNotFound {
-		// 	c.JSON(404, gin.H{"error": "device not found"})
-		// 	return
-		// }
+		if err == service.ErrInvalidIdempotency {
+			c.JSON(400, gin.H{"error": "idempotency key must be at most 255 characters"})
+			return
+		}
+		if err == service.ErrUnknownDevice {
+			c.JSON(403, gin.H{"error": "unknown device"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidReading) {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		if err == service.ErrRegistryUnavailable {
+			c.JSON(503, gin.H{"error": "device registry unavailable"})
+			return
+		}
 		c.JSON(500, gin.H{"error": "internal server error"})
 		return
 	}
+	if !created {
+		c.Header("Idempotent-Replayed", "true")
+		c.JSON(200, responseWithMessage{Message: "Telemetry Already Recorded", Data: data})
+		return
+	}
 	response := responseWithMessage{
 		Message: "Telemetry Inserted Successfully",
 		Data:    data,
@@ -74,30 +160,92 @@ func (h *TelemetryHandler) InsertTelemetry(c *gin.Context) {
 	c.JSON(201, response)
 }
 
+func (h *TelemetryHandler) InsertTelemetryBatch(c *gin.Context) {
+	var req struct {
+		Readings []models.TelemetryReading `json:"readings" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := h.svc.InsertTelemetryBatch(req.Readings)
+	if err != nil {
+		switch err {
+		case service.ErrEmptyBatch:
+			c.JSON(400, gin.H{"error": "readings must not be empty"})
+		case service.ErrBatchTooLarge:
+			c.JSON(413, gin.H{"error": "too many readings in one batch"})
+		default:
+			c.JSON(500, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+	// 207 tells the device to look at the per-item results.
+	status := 201
+	if result.Rejected > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, responseWithMessage{
+		Message: "Telemetry Batch Processed",
+		Data:    result,
+	})
+}
+
 // SSE stream event handler
 func (h *TelemetryHandler) StreamLatestTelemetry(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
 	deviceID := c.Param("device_id")
-	clientChan := make(chan *models.Telemetry)
-
-	subscription := service.ClientSubscription{
-		Channel:  clientChan,
-		DeviceID: deviceID,
+	if err := h.svc.AuthorizeDevice(ownerID, deviceID); err != nil {
+		if err == service.ErrDeviceNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device ownership"})
+		return
 	}
-
-	h.svc.Broker.NewClients <- subscription
+	subscription, missed, resumed := h.svc.Broker.Subscribe(deviceID, lastEventID(c))
 
 	defer func() {
 		// 3. Hapus klien yang sama saat koneksi ditutup
-		h.svc.Broker.ClosingClients <- subscription
+		h.svc.Broker.Unsubscribe(subscription)
 	}()
 
-	// ... headers ...
+	setSSEHeaders(c)
+
+	// A client that resumes from a known event only needs what it missed;
+	// everyone else starts from the latest stored reading.
+	if !resumed {
+		latest, err := h.svc.GetLatestTelemetryByDeviceID(ownerID, deviceID)
+		if err == nil && latest != nil {
+			writeSSE(c, 0, "snapshot", latest)
+		}
+	}
+	for _, ev := range missed {
+		writeSSE(c, ev.ID, "telemetry_new_data", ev.Telemetry)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 
 	for {
 		select {
-		case data := <-clientChan:
+		case ev := <-subscription.Channel:
 			// TIDAK ADA LAGI IF STATEMENT! Broker sudah memfilter data.
-			c.SSEvent("telemetry_new_data", data)
+			writeSSE(c, ev.ID, "telemetry_new_data", ev.Telemetry)
+			c.Writer.Flush()
+
+		case <-subscription.Closed:
+			// Too slow for the disconnect policy; the client resumes from
+			// its Last-Event-ID.
+			return
+
+		case <-heartbeat.C:
+			writeHeartbeat(c)
 			c.Writer.Flush()
 
 		case <-c.Request.Context().Done():
@@ -105,3 +253,13 @@ func (h *TelemetryHandler) StreamLatestTelemetry(c *gin.Context) {
 		}
 	}
 }
+
+// ListSubscriptions shows every live stream subscription with its queue
+// depth and drop counter.
+func (h *TelemetryHandler) ListSubscriptions(c *gin.Context) {
+	response := responseWithMessage{
+		Message: "Subscriptions Found",
+		Data:    h.svc.Broker.Subscriptions(),
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ServeWebSocket streams telemetry over a WebSocket using the envelope in
+// ws.go. Clients subscribe to the telemetry topic with device_ids; commands
+// belong to device-service-v2, whose gateway relays telemetry from here.
+func (h *TelemetryHandler) ServeWebSocket(c *gin.Context) {
+	ownerID := c.GetString("owner_id")
+	if ownerID == "" {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		return
+	}
+	s := newWSSession(conn)
+	defer s.close()
+
+	// stops holds one channel per subscribed device.
+	stops := map[string]chan struct{}{}
+	defer func() {
+		for _, stop := range stops {
+			close(stop)
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.fail("", "invalid message")
+			continue
+		}
+
+		switch msg.Type {
+		case wsPing:
+			s.send(&wsMessage{Type: wsPong, RequestID: msg.RequestID})
+
+		case wsSubscribe:
+			if msg.Topic != topicTelemetry {
+				s.fail(msg.RequestID, "unknown topic")
+				continue
+			}
+			if len(msg.DeviceIDs) == 0 {
+				s.fail(msg.RequestID, "device_ids is required")
+				continue
+			}
+			if err := h.authorizeDevices(ownerID, msg.DeviceIDs); err != nil {
+				if err == service.ErrDeviceNotFound {
+					s.fail(msg.RequestID, "device not found")
+				} else {
+					s.fail(msg.RequestID, "internal server error")
+				}
+				continue
+			}
+			for _, deviceID := range msg.DeviceIDs {
+				if _, ok := stops[deviceID]; ok {
+					continue
+				}
+				stop := make(chan struct{})
+				stops[deviceID] = stop
+				h.subscribeTelemetry(s, deviceID, stop)
+			}
+			s.ack(msg.RequestID, gin.H{"topic": topicTelemetry, "device_ids": msg.DeviceIDs})
+
+		case wsUnsubscribe:
+			if msg.Topic != topicTelemetry {
+				s.fail(msg.RequestID, "unknown topic")
+				continue
+			}
+			// Without device_ids every telemetry subscription ends.
+			ids := msg.DeviceIDs
+			if len(ids) == 0 {
+				for id := range stops {
+					ids = append(ids, id)
+				}
+			}
+			for _, deviceID := range ids {
+				if stop, ok := stops[deviceID]; ok {
+					close(stop)
+					delete(stops, deviceID)
+				}
+			}
+			s.ack(msg.RequestID, gin.H{"topic": topicTelemetry, "device_ids": ids})
+
+		case wsCommand:
+			s.fail(msg.RequestID, "commands are handled by device-service-v2")
+
+		default:
+			s.fail(msg.RequestID, "unknown message type")
+		}
+	}
+}
+
+// authorizeDevices checks every device before any of them is subscribed.
+func (h *TelemetryHandler) authorizeDevices(ownerID string, deviceIDs []string) error {
+	for _, id := range deviceIDs {
+		if err := h.svc.AuthorizeDevice(ownerID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribeTelemetry registers with the broker before returning, so the
+// following ack means no reading is missed, and pumps readings until stop.
+func (h *TelemetryHandler) subscribeTelemetry(s *wsSession, deviceID string, stop chan struct{}) {
+	sub, _, _ := h.svc.Broker.Subscribe(deviceID, 0)
+
+	go func() {
+		defer h.svc.Broker.Unsubscribe(sub)
+		for {
+			select {
+			case ev := <-sub.Channel:
+				if !s.send(&wsMessage{
+					Type:     wsEvent,
+					Topic:    topicTelemetry,
+					Event:    "telemetry_new_data",
+					DeviceID: deviceID,
+					ID:       ev.ID,
+					Data:     ev.Telemetry,
+				}) {
+					return
+				}
+			case <-sub.Closed:
+				// Too slow for the disconnect policy: drop the whole socket.
+				s.conn.Close()
+				return
+			case <-stop:
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
@@ -1,35 +1,117 @@
 package http
 
 import (
+	"github.com/DXR3IN/telemetry-service-v2/internal/client"
 	"github.com/DXR3IN/telemetry-service-v2/internal/config"
 	h "github.com/DXR3IN/telemetry-service-v2/internal/http/handler"
 	"github.com/DXR3IN/telemetry-service-v2/internal/http/middleware"
 	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
 	"github.com/DXR3IN/telemetry-service-v2/internal/service"
+	"github.com/DXR3IN/telemetry-service-v2/internal/storage"
 	"github.com/DXR3IN/telemetry-service-v2/internal/utils"
 	ginpkg "github.com/gin-gonic/gin"
 )
 
-func NewRouter(cfg *config.Config, repo repository.TelemetryRepository) *ginpkg.Engine {
+// Repositories bundles the data access the router wires into the services.
+type Repositories struct {
+	Telemetry  repository.TelemetryRepository
+	Rollups    repository.RollupRepository
+	Retention  repository.RetentionRepository
+	Partitions repository.PartitionRepository
+	Ingest     repository.IngestRepository
+	Metrics    repository.MetricRepository
+
+	// Archive receives raw readings removed by retention; nil disables
+	// archiving.
+	Archive storage.ArchiveStore
+
+	// BrokerBackend shares stream events between instances; nil keeps
+	// them in process.
+	BrokerBackend service.BrokerBackend
+}
+
+func NewRouter(cfg *config.Config, repos Repositories) *ginpkg.Engine {
 	r := ginpkg.Default()
 
 	jwtMgr := utils.NewJWTManagerFromEnv()
 
+	// Metric catalogue
+	metricSvc := service.NewMetricService(repos.Metrics)
+	metricHandler := h.NewMetricHandler(metricSvc)
+	go metricSvc.RunRefresh(cfg.MetricRefreshInterval)
+
 	// Telemetry routes
-	telemetrySvc := service.NewTelemetryService(repo, jwtMgr)
+	deviceClient := client.NewDeviceClient(cfg.DeviceServiceURL, cfg.InternalAPIKey)
+	owners := service.NewOwnershipResolver(deviceClient, cfg.OwnershipCacheTTL)
+	telemetrySvc := service.NewTelemetryService(repos.Telemetry, repos.Rollups, repos.Ingest, metricSvc, deviceClient, owners, jwtMgr, service.BrokerOptions{
+		Backend:    repos.BrokerBackend,
+		BufferSize: cfg.StreamBufferSize,
+		Policy:     service.SlowConsumerPolicy(cfg.SlowConsumerPolicy),
+	}, service.IngestOptions{
+		MaxBatchSize:   cfg.BatchMaxSize,
+		MaxClockAhead:  cfg.MaxClockAhead,
+		MaxClockBehind: cfg.MaxClockBehind,
+	})
 	telemetryHandler := h.NewTelemetryHandler(telemetrySvc)
 
+	rollupSvc := service.NewRollupService(repos.Rollups)
+	go rollupSvc.RunRollups(cfg.RollupInterval)
+
+	partitionSvc := service.NewPartitionService(repos.Partitions, cfg.PartitionMonthsAhead, cfg.MaxClockBehind)
+	go partitionSvc.RunMaintenance(cfg.PartitionInterval)
+
+	retentionSvc := service.NewRetentionService(repos.Retention, repos.Partitions, repos.Ingest, owners, service.RetentionOptions{
+		Global:            cfg.Retention,
+		MinRawAge:         cfg.MaxClockBehind,
+		BatchSize:         cfg.RetentionBatchSize,
+		Archive:           repos.Archive,
+		QuarantineDays:    cfg.QuarantineDays,
+		QuarantineMaxRows: cfg.QuarantineMaxRows,
+	})
+	retentionHandler := h.NewRetentionHandler(retentionSvc)
+	go retentionSvc.RunRetention(cfg.RetentionInterval)
+
 	//Backend to Frontend
+	r.GET("/api/telemetry/:device_id/stream", middleware.StreamRequired(jwtMgr), telemetryHandler.StreamLatestTelemetry)
+	r.GET("/api/telemetry/ws", middleware.RelayOrStreamRequired(jwtMgr), telemetryHandler.ServeWebSocket)
+
 	telemetry := r.Group("/api/telemetry")
 	telemetry.Use(middleware.DeviceRequired(jwtMgr))
+	telemetry.GET("/metrics", metricHandler.ListMetrics)
+	telemetry.GET("/metrics/:name", metricHandler.GetMetric)
+	telemetry.GET("/groups/:group_id", telemetryHandler.GetTelemetryByGroupID)
+	telemetry.GET("/groups/:group_id/latest", telemetryHandler.GetLatestTelemetryByGroupID)
+	telemetry.GET("/groups/:group_id/summary", telemetryHandler.SummarizeTelemetryByGroupID)
 	telemetry.GET("/:device_id", telemetryHandler.GetTelemetryByDeviceID)
 	telemetry.GET("/:device_id/latest", telemetryHandler.GetLatestTelemetry)
-	telemetry.GET("/:device_id/stream", telemetryHandler.StreamLatestTelemetry)
+	telemetry.GET("/:device_id/aggregate", telemetryHandler.AggregateTelemetry)
+	telemetry.GET("/:device_id/ingest-stats", telemetryHandler.GetIngestStats)
+	telemetry.GET("/:device_id/retention", retentionHandler.GetDeviceRetention)
+	telemetry.PUT("/:device_id/retention", retentionHandler.SetDeviceRetention)
+
+	// Service to service
+	cleanupSvc := service.NewCleanupService(repos.Telemetry, repos.Rollups, repos.Retention, owners, service.DeletePolicy(cfg.DeletePolicy))
+	internalHandler := h.NewInternalHandler(cleanupSvc)
+
+	internal := r.Group("/api/telemetry/internal")
+	internal.Use(middleware.InternalRequired())
+	internal.DELETE("/devices/:device_id", internalHandler.DeviceDeleted)
+	internal.POST("/metrics", metricHandler.CreateMetric)
+	internal.PUT("/metrics/:name", metricHandler.UpdateMetric)
+
+	// Debug routes
+	debug := r.Group("/api/telemetry/debug")
+	debug.Use(middleware.DebugRequired())
+	debug.GET("/subscriptions", telemetryHandler.ListSubscriptions)
+	debug.GET("/quarantine", telemetryHandler.ListQuarantine)
+	debug.GET("/ingest-stats", telemetryHandler.ListIngestStats)
+	debug.GET("/storage", retentionHandler.StorageUsage)
 
 	// IoT device to Backend
 	iot := r.Group("/api/telemetry/iot")
 	iot.Use(middleware.IoTRequired())
 	iot.POST("/telemetry", telemetryHandler.InsertTelemetry)
+	iot.POST("/telemetry/batch", telemetryHandler.InsertTelemetryBatch)
 	iot.POST("/status")
 
 	return r
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+)
+
+// bucketOrigin is a Monday midnight, so day and week buckets start at local
+// midnight and on Mondays.
+const bucketOrigin = "TIMESTAMP '2000-01-03 00:00:00'"
+
+// sqlExpr is a piece of SQL with the arguments of its placeholders.
+type sqlExpr struct {
+	sql  string
+	args []interface{}
+}
+
+// metricValue reads a metric from its own column for the original fields,
+// otherwise from the metrics JSONB column.
+func metricValue(name string) sqlExpr {
+	if models.IsFieldMetric(name) {
+		return sqlExpr{sql: name}
+	}
+	return sqlExpr{sql: "(metrics->>?)::double precision", args: []interface{}{name}}
+}
+
+// aggregateFunc applies fn to v. The caller has validated fn.
+func aggregateFunc(fn string, v sqlExpr) sqlExpr {
+	switch fn {
+	case models.AggregateAvg:
+		return sqlExpr{"AVG(" + v.sql + ")", v.args}
+	case models.AggregateMin:
+		return sqlExpr{"MIN(" + v.sql + ")", v.args}
+	case models.AggregateMax:
+		return sqlExpr{"MAX(" + v.sql + ")", v.args}
+	case models.AggregateCount:
+		return sqlExpr{"COUNT(" + v.sql + ")::double precision", v.args}
+	case models.AggregateStddev:
+		return sqlExpr{"STDDEV_SAMP(" + v.sql + ")", v.args}
+	case models.AggregateLast:
+		args := append(append([]interface{}{}, v.args...), v.args...)
+		return sqlExpr{"(ARRAY_AGG(" + v.sql + " ORDER BY created_at DESC) FILTER (WHERE " + v.sql + " IS NOT NULL))[1]", args}
+	}
+	p, _ := models.Percentile(fn)
+	return sqlExpr{fmt.Sprintf("PERCENTILE_CONT(%g) WITHIN GROUP (ORDER BY %s)", p, v.sql), v.args}
+}
+
+func bucketInterval(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(d/time.Second))
+}
+
+// aggregateSQL builds the query for q against table, one row per bucket.
+func aggregateSQL(table string, q *models.AggregateQuery) (string, []interface{}) {
+	tz := q.Location.String()
+	var sb strings.Builder
+	args := []interface{}{bucketInterval(q.Bucket), tz, tz}
+	sb.WriteString("SELECT date_bin(CAST(? AS interval), created_at AT TIME ZONE ?, " + bucketOrigin + ") AT TIME ZONE ? AS bucket_start")
+	for _, metric := range q.Metrics {
+		for _, fn := range q.Functions {
+			e := aggregateFunc(fn, metricValue(metric))
+			sb.WriteString(", " + e.sql)
+			args = append(args, e.args...)
+		}
+	}
+	sb.WriteString(" FROM " + table + " WHERE device_id = ? AND created_at >= ? AND created_at < ? GROUP BY 1 ORDER BY 1")
+	args = append(args, q.DeviceID, q.From, q.To)
+	return sb.String(), args
+}
+
+// aggregate runs q against table in a single query. Buckets without
+// readings are left out.
+func aggregate(db *gorm.DB, table string, q *models.AggregateQuery) ([]models.AggregateBucket, error) {
+	query, args := aggregateSQL(table, q)
+	return scanAggregate(db, query, args, q)
+}
+
+// scanAggregate runs an aggregate query whose rows hold the bucket start and
+// one value per metric and function, in the order of q.
+func scanAggregate(db *gorm.DB, query string, args []interface{}, q *models.AggregateQuery) ([]models.AggregateBucket, error) {
+	rows, err := db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []models.AggregateBucket{}
+	for rows.Next() {
+		var start time.Time
+		values := make([]*float64, len(q.Metrics)*len(q.Functions))
+		dest := make([]interface{}, 0, len(values)+1)
+		dest = append(dest, &start)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		b := models.AggregateBucket{Start: start.In(q.Location), Values: make(map[string]map[string]*float64, len(q.Metrics))}
+		i := 0
+		for _, metric := range q.Metrics {
+			byFn := make(map[string]*float64, len(q.Functions))
+			for _, fn := range q.Functions {
+				byFn[fn] = values[i]
+				i++
+			}
+			b.Values[metric] = byFn
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type QuarantinedTelemetry struct {
+	ID         string    `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID   string    `gorm:"type:varchar(255);not null;index"`
+	Reason     string    `gorm:"type:varchar(32);not null;index"`
+	Payload    string    `gorm:"type:jsonb;not null"`
+	ReceivedAt time.Time `gorm:"not null;index"`
+}
+
+func (q *QuarantinedTelemetry) ToDomain() *models.QuarantinedTelemetry {
+	return &models.QuarantinedTelemetry{
+		ID:         q.ID,
+		DeviceID:   q.DeviceID,
+		Reason:     q.Reason,
+		Payload:    json.RawMessage(q.Payload),
+		ReceivedAt: q.ReceivedAt,
+	}
+}
+
+// DeviceIngestStat holds the ingest counters of one device ID, including IDs
+// that were never registered.
+type DeviceIngestStat struct {
+	DeviceID         string `gorm:"primaryKey;type:varchar(255);not null"`
+	Accepted         int64  `gorm:"not null;default:0"`
+	Rejected         int64  `gorm:"not null;default:0"`
+	LastAcceptedAt   *time.Time
+	LastRejectedAt   *time.Time
+	LastRejectReason string `gorm:"type:varchar(32)"`
+}
+
+func (s *DeviceIngestStat) ToDomain() *models.IngestStats {
+	return &models.IngestStats{
+		DeviceID:         s.DeviceID,
+		Accepted:         s.Accepted,
+		Rejected:         s.Rejected,
+		LastAcceptedAt:   s.LastAcceptedAt,
+		LastRejectedAt:   s.LastRejectedAt,
+		LastRejectReason: s.LastRejectReason,
+	}
+}
+
+type IngestRepository interface {
+	Quarantine(q *QuarantinedTelemetry) error
+	FindQuarantined(deviceID, reason string, limit int) ([]*models.QuarantinedTelemetry, error)
+	CountAccepted(deviceID string, n int64, at time.Time) error
+	FindStats(deviceID string) (*models.IngestStats, error)
+	FindAllStats(limit int) ([]*models.IngestStats, error)
+	DeleteQuarantinedBefore(before time.Time, limit int) (int64, error)
+	TrimQuarantined(keep, limit int) (int64, error)
+	DeleteIdleRejectedStats(before time.Time, limit int) (int64, error)
+}
+
+type ingestRepo struct {
+	db *gorm.DB
+}
+
+func NewIngestRepository(db *gorm.DB) IngestRepository {
+	return &ingestRepo{db: db}
+}
+
+// Quarantine stores the rejected reading and counts the rejection in one
+// transaction.
+func (r *ingestRepo) Quarantine(q *QuarantinedTelemetry) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(q).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "device_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"rejected":           gorm.Expr("device_ingest_stats.rejected + 1"),
+				"last_rejected_at":   q.ReceivedAt,
+				"last_reject_reason": q.Reason,
+			}),
+		}).Create(&DeviceIngestStat{DeviceID: q.DeviceID, Rejected: 1, LastRejectedAt: &q.ReceivedAt, LastRejectReason: q.Reason}).Error
+	})
+}
+
+func (r *ingestRepo) FindQuarantined(deviceID, reason string, limit int) ([]*models.QuarantinedTelemetry, error) {
+	q := r.db.Order("received_at DESC").Limit(limit)
+	if deviceID != "" {
+		q = q.Where("device_id = ?", deviceID)
+	}
+	if reason != "" {
+		q = q.Where("reason = ?", reason)
+	}
+	var rows []QuarantinedTelemetry
+	if err := q.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.QuarantinedTelemetry, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *ingestRepo) CountAccepted(deviceID string, n int64, at time.Time) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "device_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"accepted":         gorm.Expr("device_ingest_stats.accepted + ?", n),
+			"last_accepted_at": at,
+		}),
+	}).Create(&DeviceIngestStat{DeviceID: deviceID, Accepted: n, LastAcceptedAt: &at}).Error
+}
+
+func (r *ingestRepo) FindStats(deviceID string) (*models.IngestStats, error) {
+	var stat DeviceIngestStat
+	if err := r.db.First(&stat, "device_id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return stat.ToDomain(), nil
+}
+
+// FindAllStats lists the devices with the most rejections first.
+func (r *ingestRepo) FindAllStats(limit int) ([]*models.IngestStats, error) {
+	var stats []DeviceIngestStat
+	if err := r.db.Order("rejected DESC, device_id ASC").Limit(limit).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.IngestStats, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s.ToDomain())
+	}
+	return result, nil
+}
+
+// DeleteQuarantinedBefore deletes up to limit readings quarantined before the
+// cutoff.
+func (r *ingestRepo) DeleteQuarantinedBefore(before time.Time, limit int) (int64, error) {
+	sub := r.db.Model(&QuarantinedTelemetry{}).Select("id").Where("received_at < ?", before).Limit(limit)
+	res := r.db.Where("id IN (?)", sub).Delete(&QuarantinedTelemetry{})
+	return res.RowsAffected, res.Error
+}
+
+// TrimQuarantined deletes up to limit readings beyond the newest keep.
+func (r *ingestRepo) TrimQuarantined(keep, limit int) (int64, error) {
+	sub := r.db.Model(&QuarantinedTelemetry{}).Select("id").
+		Order("received_at DESC").Offset(keep).Limit(limit)
+	res := r.db.Where("id IN (?)", sub).Delete(&QuarantinedTelemetry{})
+	return res.RowsAffected, res.Error
+}
+
+// DeleteIdleRejectedStats deletes up to limit counters of device IDs that
+// never had a reading accepted and were last rejected before the cutoff.
+// Registered devices keep their counters.
+func (r *ingestRepo) DeleteIdleRejectedStats(before time.Time, limit int) (int64, error) {
+	sub := r.db.Model(&DeviceIngestStat{}).Select("device_id").
+		Where("accepted = 0 AND last_rejected_at < ?", before).Limit(limit)
+	res := r.db.Where("device_id IN (?)", sub).Delete(&DeviceIngestStat{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Metric struct {
+	Name        string `gorm:"primaryKey;type:varchar(64);not null"`
+	Unit        string `gorm:"type:varchar(32);not null;default:''"`
+	Description string `gorm:"type:text"`
+	MinValue    *float64
+	MaxValue    *float64
+	Precision   *int
+	BuiltIn     bool `gorm:"not null;default:false"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (m *Metric) ToDomain() *models.Metric {
+	return &models.Metric{
+		Name:        m.Name,
+		Unit:        m.Unit,
+		Description: m.Description,
+		Min:         m.MinValue,
+		Max:         m.MaxValue,
+		Precision:   m.Precision,
+		BuiltIn:     m.BuiltIn,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+	}
+}
+
+func ToRepositoryMetric(m *models.Metric) *Metric {
+	return &Metric{
+		Name:        m.Name,
+		Unit:        m.Unit,
+		Description: m.Description,
+		MinValue:    m.Min,
+		MaxValue:    m.Max,
+		Precision:   m.Precision,
+		BuiltIn:     m.BuiltIn,
+	}
+}
+
+type MetricRepository interface {
+	FindAll() ([]*models.Metric, error)
+	FindByName(name string) (*models.Metric, error)
+	Create(m *Metric) error
+	Update(m *Metric) (*models.Metric, error)
+	EnsureDefaults(defaults []*models.Metric) error
+}
+
+type metricRepo struct {
+	db *gorm.DB
+}
+
+func NewMetricRepository(db *gorm.DB) MetricRepository {
+	return &metricRepo{db: db}
+}
+
+func (r *metricRepo) FindAll() ([]*models.Metric, error) {
+	var metrics []Metric
+	if err := r.db.Order("name ASC").Find(&metrics).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*models.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		result = append(result, m.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *metricRepo) FindByName(name string) (*models.Metric, error) {
+	var metric Metric
+	if err := r.db.First(&metric, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return metric.ToDomain(), nil
+}
+
+func (r *metricRepo) Create(m *Metric) error {
+	return r.db.Create(m).Error
+}
+
+// Update changes everything but the name and the built-in flag.
+func (r *metricRepo) Update(m *Metric) (*models.Metric, error) {
+	res := r.db.Model(&Metric{}).Where("name = ?", m.Name).
+		Select("unit", "description", "min_value", "max_value", "precision", "updated_at").
+		Updates(map[string]interface{}{
+			"unit":        m.Unit,
+			"description": m.Description,
+			"min_value":   m.MinValue,
+			"max_value":   m.MaxValue,
+			"precision":   m.Precision,
+			"updated_at":  time.Now(),
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.FindByName(m.Name)
+}
+
+// EnsureDefaults inserts the built-in metrics that are missing and leaves
+// existing ones, including operator edits, alone.
+func (r *metricRepo) EnsureDefaults(defaults []*models.Metric) error {
+	rows := make([]*Metric, 0, len(defaults))
+	for _, m := range defaults {
+		row := ToRepositoryMetric(m)
+		row.BuiltIn = true
+		rows = append(rows, row)
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+)
+
+// telemetries is partitioned by month of created_at. A unique index on a
+// partitioned table must contain created_at, so the per-device sequence
+// numbers and idempotency keys live in telemetry_dedup_keys instead.
+const (
+	partitionPrefix  = "telemetries_p"
+	partitionLayout  = "200601"
+	defaultPartition = "telemetries_default"
+)
+
+// TelemetryDedupKey claims a sequence number or idempotency key of a device
+// for the reading stored first with it.
+type TelemetryDedupKey struct {
+	DeviceID    string    `gorm:"primaryKey;type:varchar(36)"`
+	Kind        string    `gorm:"primaryKey;type:varchar(8)"`
+	Value       string    `gorm:"primaryKey;type:varchar(255)"`
+	TelemetryID string    `gorm:"type:varchar(36);not null;index"`
+	CreatedAt   time.Time `gorm:"not null;index"`
+}
+
+const (
+	dedupSequence       = "seq"
+	dedupIdempotencyKey = "key"
+)
+
+func dedupKeys(t *Telemetry) []TelemetryDedupKey {
+	var keys []TelemetryDedupKey
+	if t.Sequence != nil {
+		keys = append(keys, TelemetryDedupKey{DeviceID: t.DeviceID, Kind: dedupSequence, Value: fmt.Sprint(*t.Sequence)})
+	}
+	if t.IdempotencyKey != nil {
+		keys = append(keys, TelemetryDedupKey{DeviceID: t.DeviceID, Kind: dedupIdempotencyKey, Value: *t.IdempotencyKey})
+	}
+	for i := range keys {
+		keys[i].TelemetryID = t.ID
+		keys[i].CreatedAt = t.CreatedAt
+	}
+	return keys
+}
+
+const createPartitionedTelemetries = `CREATE TABLE telemetries (
+	id varchar(36) NOT NULL,
+	device_id varchar(36) NOT NULL,
+	ppm double precision NOT NULL,
+	water_level_on_plant double precision NOT NULL,
+	water_level_on_condenser double precision NOT NULL,
+	water_level_on_nutrient_tank double precision NOT NULL,
+	humidity double precision NOT NULL,
+	created_at timestamptz NOT NULL,
+	metrics jsonb,
+	sequence bigint,
+	idempotency_key varchar(255),
+	PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at)`
+
+const telemetryColumns = `id, device_id, ppm, water_level_on_plant, water_level_on_condenser,
+	water_level_on_nutrient_tank, humidity, created_at, metrics, sequence, idempotency_key`
+
+// MigrateTelemetryTable creates telemetries as a table partitioned by month,
+// with partitions from the month of from up to monthsAhead months from now. An unpartitioned table
+// from an earlier version is copied into it in one transaction, which may
+// take a while on a large table. telemetry_dedup_keys must already exist.
+func MigrateTelemetryTable(db *gorm.DB, from time.Time, monthsAhead int) error {
+	var relkind string
+	if err := db.Raw(`SELECT COALESCE((SELECT relkind::text FROM pg_class
+		WHERE oid = to_regclass('telemetries')), '')`).Scan(&relkind).Error; err != nil {
+		return err
+	}
+	if relkind == "p" {
+		return NewPartitionRepository(db).EnsurePartitions(from, monthsAhead)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		legacy := relkind == "r"
+		if legacy {
+			// Index names are per schema, so the old ones have to go first.
+			for _, stmt := range []string{
+				`ALTER TABLE telemetries RENAME TO telemetries_unpartitioned`,
+				`ALTER TABLE telemetries_unpartitioned DROP CONSTRAINT IF EXISTS telemetries_pkey`,
+				`DROP INDEX IF EXISTS idx_telemetries_device_sequence`,
+				`DROP INDEX IF EXISTS idx_telemetries_device_idempotency_key`,
+			} {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+		}
+		for _, stmt := range []string{
+			createPartitionedTelemetries,
+			`CREATE INDEX idx_telemetries_device_created_at ON telemetries (device_id, created_at DESC)`,
+			`CREATE TABLE ` + defaultPartition + ` PARTITION OF telemetries DEFAULT`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		if legacy {
+			var oldest *time.Time
+			if err := tx.Raw(`SELECT MIN(created_at) FROM telemetries_unpartitioned`).Scan(&oldest).Error; err != nil {
+				return err
+			}
+			if oldest != nil && oldest.Before(from) {
+				from = *oldest
+			}
+		}
+		if err := NewPartitionRepository(tx).EnsurePartitions(from, monthsAhead); err != nil {
+			return err
+		}
+		if !legacy {
+			return nil
+		}
+
+		if err := tx.Exec(`INSERT INTO telemetries (` + telemetryColumns + `)
+			SELECT ` + telemetryColumns + ` FROM telemetries_unpartitioned`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`INSERT INTO telemetry_dedup_keys (device_id, kind, value, telemetry_id, created_at)
+			SELECT device_id, ?, sequence::text, id, created_at FROM telemetries_unpartitioned WHERE sequence IS NOT NULL
+			UNION ALL
+			SELECT device_id, ?, idempotency_key, id, created_at FROM telemetries_unpartitioned WHERE idempotency_key IS NOT NULL
+			ON CONFLICT DO NOTHING`, dedupSequence, dedupIdempotencyKey).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`DROP TABLE telemetries_unpartitioned`).Error
+	})
+}
+
+type PartitionRepository interface {
+	EnsurePartitions(from time.Time, monthsAhead int) error
+	ListPartitions() ([]models.TelemetryPartition, error)
+	ReadPartition(name string, afterTime time.Time, afterID string, limit int) ([]*Telemetry, error)
+	HasPendingRollups(p models.TelemetryPartition) (bool, error)
+	DropPartition(p models.TelemetryPartition) error
+}
+
+type partitionRepo struct {
+	db *gorm.DB
+}
+
+func NewPartitionRepository(db *gorm.DB) PartitionRepository {
+	return &partitionRepo{db: db}
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsurePartitions creates the monthly partitions from the month of from
+// through monthsAhead months after the current one. Readings of a new month
+// that already landed in the default partition are moved into it.
+func (r *partitionRepo) EnsurePartitions(from time.Time, monthsAhead int) error {
+	last := monthStart(time.Now()).AddDate(0, monthsAhead, 0)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Replicas run this at the same time; one creates, the others see it.
+		if err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('telemetries_partitions'))`).Error; err != nil {
+			return err
+		}
+		for m := monthStart(from); !m.After(last); m = m.AddDate(0, 1, 0) {
+			if err := createPartition(tx, m); err != nil {
+				return fmt.Errorf("creating partition for %s: %w", m.Format("2006-01"), err)
+			}
+		}
+		return nil
+	})
+}
+
+// createPartition creates the partition of month m unless it exists. The
+// table is filled from the default partition before it is attached, since
+// Postgres refuses a partition whose rows are still in the default one.
+func createPartition(tx *gorm.DB, m time.Time) error {
+	name := partitionPrefix + m.Format(partitionLayout)
+	var exists bool
+	if err := tx.Raw(`SELECT to_regclass(?) IS NOT NULL`, name).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	start, end := m.Format(time.RFC3339), m.AddDate(0, 1, 0).Format(time.RFC3339)
+	for _, stmt := range []string{
+		fmt.Sprintf(`CREATE TABLE %s (LIKE telemetries INCLUDING DEFAULTS)`, name),
+		fmt.Sprintf(`WITH moved AS (DELETE FROM %s WHERE created_at >= '%s' AND created_at < '%s' RETURNING %s)
+			INSERT INTO %s (%s) SELECT %s FROM moved`,
+			defaultPartition, start, end, telemetryColumns, name, telemetryColumns, telemetryColumns),
+		fmt.Sprintf(`ALTER TABLE telemetries ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`, name, start, end),
+	} {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPartitions returns the monthly partitions, oldest first, and the
+// default partition last.
+func (r *partitionRepo) ListPartitions() ([]models.TelemetryPartition, error) {
+	var rows []struct {
+		Name          string
+		Bytes         int64
+		EstimatedRows int64
+	}
+	if err := r.db.Raw(`SELECT c.relname AS name,
+			pg_total_relation_size(c.oid) AS bytes,
+			GREATEST(c.reltuples, 0)::bigint AS estimated_rows
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'telemetries'::regclass
+		ORDER BY c.relname`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	var monthly []models.TelemetryPartition
+	var rest []models.TelemetryPartition
+	for _, row := range rows {
+		p := models.TelemetryPartition{Name: row.Name, Bytes: row.Bytes, EstimatedRows: row.EstimatedRows}
+		if len(row.Name) == len(partitionPrefix)+len(partitionLayout) && row.Name[:len(partitionPrefix)] == partitionPrefix {
+			if from, err := time.Parse(partitionLayout, row.Name[len(partitionPrefix):]); err == nil {
+				to := from.AddDate(0, 1, 0)
+				p.From, p.To = &from, &to
+				monthly = append(monthly, p)
+				continue
+			}
+		}
+		rest = append(rest, p)
+	}
+	return append(monthly, rest...), nil
+}
+
+// ReadPartition pages through one partition in (created_at, id) order.
+func (r *partitionRepo) ReadPartition(name string, afterTime time.Time, afterID string, limit int) ([]*Telemetry, error) {
+	var rows []*Telemetry
+	if err := r.db.Table(name).
+		Where("(created_at, id) > (?, ?)", afterTime, afterID).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// HasPendingRollups reports whether an hour of the partition still waits
+// for its rollup.
+func (r *partitionRepo) HasPendingRollups(p models.TelemetryPartition) (bool, error) {
+	var pending bool
+	err := r.db.Raw(`SELECT EXISTS (SELECT 1 FROM telemetry_rollup_dirties
+		WHERE resolution = ? AND bucket_start >= ? AND bucket_start < ?)`,
+		models.ResolutionHourly, *p.From, *p.To).Scan(&pending).Error
+	return pending, err
+}
+
+// DropPartition detaches and drops a monthly partition together with the
+// deduplication keys of its readings.
+func (r *partitionRepo) DropPartition(p models.TelemetryPartition) error {
+	if p.From == nil {
+		return fmt.Errorf("partition %s has no monthly range", p.Name)
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("created_at >= ? AND created_at < ?", *p.From, *p.To).
+			Delete(&TelemetryDedupKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`ALTER TABLE telemetries DETACH PARTITION ` + p.Name).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`DROP TABLE ` + p.Name).Error
+	})
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TelemetryRetentionPolicy is a device's override of the global retention
+// policy. A nil column inherits the global value.
+type TelemetryRetentionPolicy struct {
+	DeviceID   string `gorm:"primaryKey;type:varchar(36)"`
+	RawDays    *int
+	HourlyDays *int
+	DailyDays  *int
+	UpdatedAt  time.Time
+}
+
+func (p *TelemetryRetentionPolicy) ToDomain() *models.RetentionOverride {
+	return &models.RetentionOverride{
+		RawDays:    p.RawDays,
+		HourlyDays: p.HourlyDays,
+		DailyDays:  p.DailyDays,
+	}
+}
+
+// retentionColumns maps a resolution to its override column and table.
+var retentionColumns = map[string]struct{ column, table, timeColumn string }{
+	models.ResolutionRaw:    {"raw_days", "telemetries", "created_at"},
+	models.ResolutionHourly: {"hourly_days", "telemetry_rollups_hourly", "bucket_start"},
+	models.ResolutionDaily:  {"daily_days", "telemetry_rollups_daily", "bucket_start"},
+}
+
+type RetentionRepository interface {
+	FindOverride(deviceID string) (*models.RetentionOverride, error)
+	FindOverrides() (map[string]*models.RetentionOverride, error)
+	SaveOverride(deviceID string, o *models.RetentionOverride) error
+	DeleteOverride(deviceID string) error
+	FindExpiredRaw(deviceID string, before time.Time, limit int) ([]*Telemetry, error)
+	DeleteRaw(ids []string) (int64, error)
+	DeleteExpiredRollups(resolution, deviceID string, before time.Time, limit int) (int64, error)
+	DeviceStorage(deviceID string) (*models.DeviceStorage, error)
+	TableUsage() ([]models.TableUsage, error)
+	CountOverrides() (int64, error)
+}
+
+type retentionRepo struct {
+	db *gorm.DB
+}
+
+func NewRetentionRepository(db *gorm.DB) RetentionRepository {
+	return &retentionRepo{db: db}
+}
+
+func (r *retentionRepo) FindOverride(deviceID string) (*models.RetentionOverride, error) {
+	var p TelemetryRetentionPolicy
+	if err := r.db.First(&p, "device_id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return p.ToDomain(), nil
+}
+
+func (r *retentionRepo) FindOverrides() (map[string]*models.RetentionOverride, error) {
+	var policies []TelemetryRetentionPolicy
+	if err := r.db.Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]*models.RetentionOverride, len(policies))
+	for _, p := range policies {
+		result[p.DeviceID] = p.ToDomain()
+	}
+	return result, nil
+}
+
+func (r *retentionRepo) SaveOverride(deviceID string, o *models.RetentionOverride) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"raw_days", "hourly_days", "daily_days", "updated_at"}),
+	}).Create(&TelemetryRetentionPolicy{
+		DeviceID:   deviceID,
+		RawDays:    o.RawDays,
+		HourlyDays: o.HourlyDays,
+		DailyDays:  o.DailyDays,
+	}).Error
+}
+
+func (r *retentionRepo) DeleteOverride(deviceID string) error {
+	return r.db.Where("device_id = ?", deviceID).Delete(&TelemetryRetentionPolicy{}).Error
+}
+
+// scope limits a retention query to deviceID, or with an empty deviceID to
+// the devices that do not override this resolution.
+func (r *retentionRepo) scope(q *gorm.DB, resolution, deviceID string) *gorm.DB {
+	if deviceID != "" {
+		return q.Where("device_id = ?", deviceID)
+	}
+	return q.Where("device_id NOT IN (SELECT device_id FROM telemetry_retention_policies WHERE " + retentionColumns[resolution].column + " IS NOT NULL)")
+}
+
+// FindExpiredRaw returns the oldest readings created before the cutoff.
+// Hours still waiting for their rollup are skipped until it is computed.
+func (r *retentionRepo) FindExpiredRaw(deviceID string, before time.Time, limit int) ([]*Telemetry, error) {
+	q := r.scope(r.db.Model(&Telemetry{}), models.ResolutionRaw, deviceID).
+		Where("created_at < ?", before).
+		Where(`NOT EXISTS (SELECT 1 FROM telemetry_rollup_dirties d
+			WHERE d.device_id = telemetries.device_id AND d.resolution = ?
+			AND d.bucket_start = date_trunc('hour', telemetries.created_at, 'UTC'))`, models.ResolutionHourly)
+	var rows []*Telemetry
+	if err := q.Order("created_at ASC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *retentionRepo) DeleteRaw(ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("telemetry_id IN ?", ids).Delete(&TelemetryDedupKey{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("id IN ?", ids).Delete(&Telemetry{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+// DeleteExpiredRollups deletes up to limit rollups of resolution that start
+// before the cutoff.
+func (r *retentionRepo) DeleteExpiredRollups(resolution, deviceID string, before time.Time, limit int) (int64, error) {
+	table := retentionColumns[resolution].table
+	sub := r.scope(r.db.Table(table).Select("device_id, metric, bucket_start"), resolution, deviceID).
+		Where("bucket_start < ?", before).
+		Limit(limit)
+	res := r.db.Exec("DELETE FROM "+table+" WHERE (device_id, metric, bucket_start) IN (?)", sub)
+	return res.RowsAffected, res.Error
+}
+
+func (r *retentionRepo) DeviceStorage(deviceID string) (*models.DeviceStorage, error) {
+	var s models.DeviceStorage
+	if err := r.db.Raw(`SELECT
+			(SELECT COUNT(*) FROM telemetries WHERE device_id = @id) AS raw_readings,
+			(SELECT COUNT(*) FROM telemetry_rollups_hourly WHERE device_id = @id) AS hourly_rollups,
+			(SELECT COUNT(*) FROM telemetry_rollups_daily WHERE device_id = @id) AS daily_rollups,
+			(SELECT MIN(created_at) FROM telemetries WHERE device_id = @id) AS oldest_reading,
+			(SELECT MAX(created_at) FROM telemetries WHERE device_id = @id) AS newest_reading`,
+		map[string]interface{}{"id": deviceID}).
+		Scan(&s).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// TableUsage reports the on-disk size of the telemetry tables, indexes
+// included, and Postgres' row estimate. A partitioned table reports the sum
+// of its partitions.
+func (r *retentionRepo) TableUsage() ([]models.TableUsage, error) {
+	tables := []string{"telemetries", "telemetry_dedup_keys", "telemetry_rollups_hourly",
+		"telemetry_rollups_daily", "telemetry_archives", "quarantined_telemetries"}
+	var usage []models.TableUsage
+	if err := r.db.Raw(`SELECT c.relname AS "table",
+			SUM(pg_total_relation_size(t.relid))::bigint AS bytes,
+			SUM(GREATEST(p.reltuples, 0))::bigint AS estimated_rows
+		FROM pg_class c
+		CROSS JOIN LATERAL pg_partition_tree(c.oid) t
+		JOIN pg_class p ON p.oid = t.relid
+		WHERE c.relname IN ? AND c.relkind IN ('r', 'p') AND t.isleaf
+		GROUP BY c.relname
+		ORDER BY bytes DESC`, tables).
+		Scan(&usage).Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (r *retentionRepo) CountOverrides() (int64, error) {
+	var n int64
+	err := r.db.Model(&TelemetryRetentionPolicy{}).Count(&n).Error
+	return n, err
+}
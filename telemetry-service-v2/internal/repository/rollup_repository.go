@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TelemetryRollup summarizes one metric of one device over a UTC hour or
+// day. Sums rather than averages are kept so buckets can be combined.
+type TelemetryRollup struct {
+	DeviceID    string    `gorm:"primaryKey;type:varchar(36)"`
+	Metric      string    `gorm:"primaryKey;type:varchar(64)"`
+	BucketStart time.Time `gorm:"primaryKey"`
+	SampleCount int64     `gorm:"not null"`
+	ValueSum    float64   `gorm:"not null"`
+	ValueSumSq  float64   `gorm:"not null"`
+	ValueMin    float64   `gorm:"not null"`
+	ValueMax    float64   `gorm:"not null"`
+	LastValue   float64   `gorm:"not null"`
+	LastAt      time.Time `gorm:"not null"`
+}
+
+type TelemetryRollupHourly struct {
+	TelemetryRollup
+}
+
+func (TelemetryRollupHourly) TableName() string { return "telemetry_rollups_hourly" }
+
+type TelemetryRollupDaily struct {
+	TelemetryRollup
+}
+
+func (TelemetryRollupDaily) TableName() string { return "telemetry_rollups_daily" }
+
+// TelemetryRollupDirty marks a device's hour or day whose rollup has to be
+// recomputed. Every new mark raises Version, so the rollup job only clears
+// markers that did not change while it worked.
+type TelemetryRollupDirty struct {
+	DeviceID    string    `gorm:"primaryKey;type:varchar(36)"`
+	Resolution  string    `gorm:"primaryKey;type:varchar(8)"`
+	BucketStart time.Time `gorm:"primaryKey"`
+	Version     int64     `gorm:"not null;default:1"`
+}
+
+type RollupRepository interface {
+	FindDirty(resolution string, limit int) ([]*TelemetryRollupDirty, error)
+	RefreshHour(d *TelemetryRollupDirty) error
+	RefreshDay(d *TelemetryRollupDirty) error
+	MarkAllDirty() error
+	Aggregate(resolution string, q *models.AggregateQuery) ([]models.AggregateBucket, error)
+	DeleteByDeviceID(deviceID string) error
+}
+
+type rollupRepo struct {
+	db *gorm.DB
+}
+
+func NewRollupRepository(db *gorm.DB) RollupRepository {
+	return &rollupRepo{db: db}
+}
+
+// markRollupDirty queues the hour containing at for recomputation. It runs
+// in the transaction that stores the reading, so no reading is missed.
+func markRollupDirty(tx *gorm.DB, deviceID, resolution string, bucketStart time.Time) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "device_id"}, {Name: "resolution"}, {Name: "bucket_start"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"version": gorm.Expr("telemetry_rollup_dirties.version + 1"),
+		}),
+	}).Create(&TelemetryRollupDirty{DeviceID: deviceID, Resolution: resolution, BucketStart: bucketStart, Version: 1}).Error
+}
+
+func (r *rollupRepo) FindDirty(resolution string, limit int) ([]*TelemetryRollupDirty, error) {
+	var dirty []*TelemetryRollupDirty
+	if err := r.db.Where("resolution = ?", resolution).
+		Order("bucket_start ASC").
+		Limit(limit).
+		Find(&dirty).Error; err != nil {
+		return nil, err
+	}
+	return dirty, nil
+}
+
+// rollupUpsert overwrites a bucket with freshly computed values.
+const rollupUpsert = `ON CONFLICT (device_id, metric, bucket_start) DO UPDATE SET
+	sample_count = EXCLUDED.sample_count,
+	value_sum = EXCLUDED.value_sum,
+	value_sum_sq = EXCLUDED.value_sum_sq,
+	value_min = EXCLUDED.value_min,
+	value_max = EXCLUDED.value_max,
+	last_value = EXCLUDED.last_value,
+	last_at = EXCLUDED.last_at`
+
+// RefreshHour recomputes one device hour from the raw readings, queues its
+// day and clears the marker.
+func (r *rollupRepo) RefreshHour(d *TelemetryRollupDirty) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO telemetry_rollups_hourly
+				(device_id, metric, bucket_start, sample_count, value_sum, value_sum_sq,
+				 value_min, value_max, last_value, last_at)
+			SELECT t.device_id, v.metric, date_trunc('hour', t.created_at, 'UTC'),
+				COUNT(*), SUM(v.value), SUM(v.value * v.value), MIN(v.value), MAX(v.value),
+				(ARRAY_AGG(v.value ORDER BY t.created_at DESC))[1], MAX(t.created_at)
+			FROM telemetries t
+			CROSS JOIN LATERAL (
+				VALUES ('ppm', t.ppm),
+					('water_level_on_plant', t.water_level_on_plant),
+					('water_level_on_condenser', t.water_level_on_condenser),
+					('water_level_on_nutrient_tank', t.water_level_on_nutrient_tank),
+					('humidity', t.humidity)
+				UNION ALL
+				SELECT key, value::double precision
+				FROM jsonb_each_text(CASE WHEN jsonb_typeof(t.metrics) = 'object' THEN t.metrics ELSE '{}'::jsonb END)
+			) AS v(metric, value)
+			WHERE t.device_id = ? AND t.created_at >= ? AND t.created_at < ?
+			GROUP BY 1, 2, 3
+			`+rollupUpsert, d.DeviceID, d.BucketStart, d.BucketStart.Add(time.Hour)).Error; err != nil {
+			return err
+		}
+		day := d.BucketStart.UTC().Truncate(24 * time.Hour)
+		if err := markRollupDirty(tx, d.DeviceID, models.ResolutionDaily, day); err != nil {
+			return err
+		}
+		return clearDirty(tx, d)
+	})
+}
+
+// RefreshDay recomputes one device day from its hourly rollups and clears
+// the marker.
+func (r *rollupRepo) RefreshDay(d *TelemetryRollupDirty) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO telemetry_rollups_daily
+				(device_id, metric, bucket_start, sample_count, value_sum, value_sum_sq,
+				 value_min, value_max, last_value, last_at)
+			SELECT device_id, metric, date_trunc('day', bucket_start, 'UTC'),
+				SUM(sample_count), SUM(value_sum), SUM(value_sum_sq), MIN(value_min), MAX(value_max),
+				(ARRAY_AGG(last_value ORDER BY last_at DESC))[1], MAX(last_at)
+			FROM telemetry_rollups_hourly
+			WHERE device_id = ? AND bucket_start >= ? AND bucket_start < ?
+			GROUP BY 1, 2, 3
+			`+rollupUpsert, d.DeviceID, d.BucketStart, d.BucketStart.Add(24*time.Hour)).Error; err != nil {
+			return err
+		}
+		return clearDirty(tx, d)
+	})
+}
+
+// clearDirty removes the marker unless it was marked again meanwhile.
+func clearDirty(tx *gorm.DB, d *TelemetryRollupDirty) error {
+	return tx.Where("device_id = ? AND resolution = ? AND bucket_start = ? AND version = ?",
+		d.DeviceID, d.Resolution, d.BucketStart, d.Version).
+		Delete(&TelemetryRollupDirty{}).Error
+}
+
+// MarkAllDirty queues every hour that has readings. It fills the rollups of
+// readings stored before they existed.
+func (r *rollupRepo) MarkAllDirty() error {
+	return r.db.Exec(`INSERT INTO telemetry_rollup_dirties (device_id, resolution, bucket_start, version)
+		SELECT DISTINCT device_id, ?, date_trunc('hour', created_at, 'UTC'), 1 FROM telemetries
+		ON CONFLICT DO NOTHING`, models.ResolutionHourly).Error
+}
+
+func (r *rollupRepo) Aggregate(resolution string, q *models.AggregateQuery) ([]models.AggregateBucket, error) {
+	table := "telemetry_rollups_hourly"
+	if resolution == models.ResolutionDaily {
+		table = "telemetry_rollups_daily"
+	}
+	query, args := aggregateRollupSQL(table, q)
+	return scanAggregate(r.db, query, args, q)
+}
+
+func (r *rollupRepo) DeleteByDeviceID(deviceID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []interface{}{&TelemetryRollupHourly{}, &TelemetryRollupDaily{}, &TelemetryRollupDirty{}} {
+			if err := tx.Where("device_id = ?", deviceID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rollupFunc computes fn for one metric from the rollup columns. The caller
+// has ruled out percentiles.
+func rollupFunc(fn, metric string) sqlExpr {
+	filter := func(expr string) string { return expr + " FILTER (WHERE metric = ?)" }
+	var sql string
+	switch fn {
+	case models.AggregateAvg:
+		sql = filter("SUM(value_sum)") + " / NULLIF(" + filter("SUM(sample_count)") + ", 0)"
+	case models.AggregateMin:
+		sql = filter("MIN(value_min)")
+	case models.AggregateMax:
+		sql = filter("MAX(value_max)")
+	case models.AggregateCount:
+		sql = "COALESCE(" + filter("SUM(sample_count)") + ", 0)::double precision"
+	case models.AggregateLast:
+		sql = "(" + filter("ARRAY_AGG(last_value ORDER BY last_at DESC)") + ")[1]"
+	default:
+		// Sample standard deviation from the count, sum and sum of squares.
+		n, s, sq := filter("SUM(sample_count)"), filter("SUM(value_sum)"), filter("SUM(value_sum_sq)")
+		sql = "CASE WHEN " + n + " > 1 THEN SQRT(GREATEST((" + sq + " - " + s + " * " + s + " / " + n + ") / (" + n + " - 1), 0)) END"
+	}
+	// Every placeholder is the metric name.
+	args := make([]interface{}, strings.Count(sql, "?"))
+	for i := range args {
+		args[i] = metric
+	}
+	return sqlExpr{sql, args}
+}
+
+func aggregateRollupSQL(table string, q *models.AggregateQuery) (string, []interface{}) {
+	tz := q.Location.String()
+	var sb strings.Builder
+	args := []interface{}{bucketInterval(q.Bucket), tz, tz}
+	sb.WriteString("SELECT date_bin(CAST(? AS interval), bucket_start AT TIME ZONE ?, " + bucketOrigin + ") AT TIME ZONE ? AS bucket_start")
+	for _, metric := range q.Metrics {
+		for _, fn := range q.Functions {
+			e := rollupFunc(fn, metric)
+			sb.WriteString(", " + e.sql)
+			args = append(args, e.args...)
+		}
+	}
+	sb.WriteString(" FROM " + table + " WHERE device_id = ? AND metric IN ? AND bucket_start >= ? AND bucket_start < ? GROUP BY 1 ORDER BY 1")
+	args = append(args, q.DeviceID, q.Metrics, q.From, q.To)
+	return sb.String(), args
+}
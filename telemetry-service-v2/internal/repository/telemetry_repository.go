@@ -6,8 +6,11 @@ import (
 
 	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Telemetry maps the partitioned telemetries table, which
+// MigrateTelemetryTable creates instead of AutoMigrate.
 type Telemetry struct {
 	ID                       string    `gorm:"primaryKey;type:varchar(36);not null"`
 	DeviceID                 string    `gorm:"type:varchar(36);not null"`
@@ -17,6 +20,31 @@ type Telemetry struct {
 	WaterLevelOnNutrientTank float64   `gorm:"not null"`
 	Humidity                 float64   `gorm:"not null"`
 	CreatedAt                time.Time `gorm:"not null"`
+	// Metrics holds every catalogue metric beyond the fixed columns.
+	Metrics map[string]float64 `gorm:"serializer:json;type:jsonb"`
+	// Sequence and IdempotencyKey deduplicate resent readings through
+	// telemetry_dedup_keys.
+	Sequence       *int64
+	IdempotencyKey *string `gorm:"type:varchar(255)"`
+}
+
+// TelemetryArchive keeps the readings of deleted devices when the delete
+// policy is "archive". It has the columns of Telemetry but no deduplication:
+// a device ID can be registered again after a purge and reuse its sequence
+// numbers.
+type TelemetryArchive struct {
+	ID                       string             `gorm:"primaryKey;type:varchar(36);not null"`
+	DeviceID                 string             `gorm:"type:varchar(36);not null"`
+	Ppm                      float64            `gorm:"not null"`
+	WaterLevelOnPlant        float64            `gorm:"not null"`
+	WaterLevelOnCondenser    float64            `gorm:"not null"`
+	WaterLevelOnNutrientTank float64            `gorm:"not null"`
+	Humidity                 float64            `gorm:"not null"`
+	CreatedAt                time.Time          `gorm:"not null"`
+	Metrics                  map[string]float64 `gorm:"serializer:json;type:jsonb"`
+	Sequence                 *int64
+	IdempotencyKey           *string   `gorm:"type:varchar(255)"`
+	ArchivedAt               time.Time `gorm:"not null;index"`
 }
 
 func (t *Telemetry) ToDomain() *models.Telemetry {
@@ -32,6 +60,9 @@ func (t *Telemetry) ToDomain() *models.Telemetry {
 		WaterLevelOnNutrientTank: t.WaterLevelOnNutrientTank,
 		Humidity:                 t.Humidity,
 		CreatedAt:                t.CreatedAt,
+		Metrics:                  t.Metrics,
+		Sequence:                 t.Sequence,
+		IdempotencyKey:           stringValue(t.IdempotencyKey),
 	}
 }
 
@@ -40,19 +71,44 @@ func ToRepository(t *models.Telemetry) *Telemetry {
 		return nil
 	}
 	return &Telemetry{
+		ID:                       t.ID,
 		DeviceID:                 t.DeviceID,
 		Ppm:                      t.Ppm,
 		WaterLevelOnPlant:        t.WaterLevelOnPlant,
 		WaterLevelOnCondenser:    t.WaterLevelOnCondenser,
 		WaterLevelOnNutrientTank: t.WaterLevelOnNutrientTank,
 		Humidity:                 t.Humidity,
+		Metrics:                  t.Metrics,
+		Sequence:                 t.Sequence,
+		IdempotencyKey:           stringPtr(t.IdempotencyKey),
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
 	}
+	return *s
+}
+
+func stringPtr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
 
 type TelemetryRepository interface {
-	TelemetryInserted(t *Telemetry) (*Telemetry, error)
-	GetTelemetryByDeviceID(duration time.Duration, deviceID string) ([]*models.Telemetry, error)
+	TelemetryInserted(t *Telemetry) (*Telemetry, bool, error)
+	InsertBatch(rows []*Telemetry) ([]*Telemetry, []bool, error)
+	GetTelemetryByDeviceID(q *models.HistoryQuery, limit int) ([]*models.Telemetry, error)
 	GetLatestTelemetryByDeviceID(deviceID string) (*models.Telemetry, error)
+	GetTelemetryByDeviceIDs(duration time.Duration, deviceIDs []string) ([]*models.Telemetry, error)
+	GetLatestTelemetryByDeviceIDs(deviceIDs []string) ([]*models.Telemetry, error)
+	SummarizeByDeviceIDs(duration time.Duration, deviceIDs []string) (*models.TelemetrySummary, error)
+	Aggregate(q *models.AggregateQuery) ([]models.AggregateBucket, error)
+	DeleteByDeviceID(deviceID string) (int64, error)
+	ArchiveByDeviceID(deviceID string) (int64, error)
 }
 
 type telemetryRepo struct {
@@ -63,29 +119,127 @@ func NewTelemetryRepository(db *gorm.DB) TelemetryRepository {
 	return &telemetryRepo{db: db}
 }
 
-func (r *telemetryRepo) TelemetryInserted(t *Telemetry) (*Telemetry, error) {
-	if err := r.db.Create(t).Error; err != nil {
-		return nil, err
+// TelemetryInserted stores t unless the device already sent a reading with
+// the same sequence number or idempotency key. It returns the stored reading
+// and whether it was inserted now.
+func (r *telemetryRepo) TelemetryInserted(t *Telemetry) (*Telemetry, bool, error) {
+	var stored *Telemetry
+	var inserted bool
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if stored, inserted, err = insertOnce(tx, t); err != nil || !inserted {
+			return err
+		}
+		return markRollupDirty(tx, t.DeviceID, models.ResolutionHourly, rollupHour(t.CreatedAt))
+	})
+	if err != nil {
+		return nil, false, err
 	}
-	return t, nil
+	return stored, inserted, nil
 }
 
-func (r *telemetryRepo) GetTelemetryByDeviceID(duration time.Duration, deviceID string) ([]*models.Telemetry, error) {
-	var telemetry []Telemetry
-	timeStart := time.Now().Add(-duration)
-	if err := r.db.
-		Where("device_id = ? AND created_at >= ?", deviceID, timeStart).
-		Order("created_at DESC").
-		Find(&telemetry).Error; err != nil {
+// InsertBatch inserts the rows like TelemetryInserted, all or none of them in
+// one transaction. Rows resent within the same batch resolve to the first.
+func (r *telemetryRepo) InsertBatch(rows []*Telemetry) ([]*Telemetry, []bool, error) {
+	stored := make([]*Telemetry, len(rows))
+	inserted := make([]bool, len(rows))
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		type deviceHour struct {
+			deviceID string
+			hour     time.Time
+		}
+		hours := make(map[deviceHour]struct{})
+		for i, row := range rows {
+			var err error
+			if stored[i], inserted[i], err = insertOnce(tx, row); err != nil {
+				return err
+			}
+			if inserted[i] {
+				hours[deviceHour{row.DeviceID, rollupHour(row.CreatedAt)}] = struct{}{}
+			}
+		}
+		for h := range hours {
+			if err := markRollupDirty(tx, h.deviceID, models.ResolutionHourly, h.hour); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return stored, inserted, nil
+}
+
+func rollupHour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// insertOnce claims t's deduplication keys and inserts t. When a key is
+// already claimed the claims are rolled back and the reading that holds it
+// is returned instead. db must be a transaction.
+func insertOnce(db *gorm.DB, t *Telemetry) (*Telemetry, bool, error) {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	keys := dedupKeys(t)
+	if len(keys) > 0 {
+		if err := db.SavePoint("dedup").Error; err != nil {
+			return nil, false, err
+		}
+	}
+	for _, key := range keys {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&key)
+		if res.Error != nil {
+			return nil, false, res.Error
+		}
+		if res.RowsAffected > 0 {
+			continue
+		}
+		if err := db.RollbackTo("dedup").Error; err != nil {
+			return nil, false, err
+		}
+		var claim TelemetryDedupKey
+		if err := db.First(&claim, "device_id = ? AND kind = ? AND value = ?", key.DeviceID, key.Kind, key.Value).Error; err != nil {
+			return nil, false, err
+		}
+		var original Telemetry
+		if err := db.First(&original, "id = ? AND created_at = ?", claim.TelemetryID, claim.CreatedAt).Error; err != nil {
+			return nil, false, err
+		}
+		return &original, false, nil
+	}
+	if err := db.Create(t).Error; err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
 
+// GetTelemetryByDeviceID returns up to limit readings of q's window in q's
+// order, starting after q.After. Readings created at the same instant are
+// ordered by id so that pages never skip or repeat one.
+func (r *telemetryRepo) GetTelemetryByDeviceID(q *models.HistoryQuery, limit int) ([]*models.Telemetry, error) {
+	db := r.db.Where("device_id = ? AND created_at >= ? AND created_at < ?", q.DeviceID, q.From, q.To)
+	if q.Order == models.OrderAsc {
+		if q.After != nil {
+			db = db.Where("(created_at, id) > (?, ?)", q.After.CreatedAt, q.After.ID)
+		}
+		db = db.Order("created_at ASC, id ASC")
+	} else {
+		if q.After != nil {
+			db = db.Where("(created_at, id) < (?, ?)", q.After.CreatedAt, q.After.ID)
+		}
+		db = db.Order("created_at DESC, id DESC")
+	}
+	var telemetry []Telemetry
+	if err := db.Limit(limit).Find(&telemetry).Error; err != nil {
 		return nil, err
 	}
 
-	var result []*models.Telemetry
+	result := make([]*models.Telemetry, 0, len(telemetry))
 	for _, t := range telemetry {
 		result = append(result, t.ToDomain())
 	}
-
 	return result, nil
 }
 
@@ -102,3 +256,129 @@ func (r *telemetryRepo) GetLatestTelemetryByDeviceID(deviceID string) (*models.T
 	}
 	return telemetry.ToDomain(), nil
 }
+
+func (r *telemetryRepo) GetTelemetryByDeviceIDs(duration time.Duration, deviceIDs []string) ([]*models.Telemetry, error) {
+	var telemetry []Telemetry
+	timeStart := time.Now().Add(-duration)
+	if err := r.db.
+		Where("device_id IN ? AND created_at >= ?", deviceIDs, timeStart).
+		Order("created_at DESC").
+		Find(&telemetry).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.Telemetry, 0, len(telemetry))
+	for _, t := range telemetry {
+		result = append(result, t.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *telemetryRepo) GetLatestTelemetryByDeviceIDs(deviceIDs []string) ([]*models.Telemetry, error) {
+	var telemetry []Telemetry
+	if err := r.db.
+		Raw(`SELECT DISTINCT ON (device_id) * FROM telemetries
+			WHERE device_id IN ?
+			ORDER BY device_id, created_at DESC`, deviceIDs).
+		Scan(&telemetry).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.Telemetry, 0, len(telemetry))
+	for _, t := range telemetry {
+		result = append(result, t.ToDomain())
+	}
+	return result, nil
+}
+
+func (r *telemetryRepo) SummarizeByDeviceIDs(duration time.Duration, deviceIDs []string) (*models.TelemetrySummary, error) {
+	var row struct {
+		DeviceCount                              int64
+		SampleCount                              int64
+		PpmAvg, PpmMin, PpmMax                   float64
+		PlantAvg, PlantMin, PlantMax             float64
+		CondenserAvg, CondenserMin, CondenserMax float64
+		NutrientAvg, NutrientMin, NutrientMax    float64
+		HumidityAvg, HumidityMin, HumidityMax    float64
+	}
+	to := time.Now()
+	from := to.Add(-duration)
+	if err := r.db.Raw(`SELECT
+			COUNT(DISTINCT device_id) AS device_count,
+			COUNT(*) AS sample_count,
+			COALESCE(AVG(ppm), 0) AS ppm_avg,
+			COALESCE(MIN(ppm), 0) AS ppm_min,
+			COALESCE(MAX(ppm), 0) AS ppm_max,
+			COALESCE(AVG(water_level_on_plant), 0) AS plant_avg,
+			COALESCE(MIN(water_level_on_plant), 0) AS plant_min,
+			COALESCE(MAX(water_level_on_plant), 0) AS plant_max,
+			COALESCE(AVG(water_level_on_condenser), 0) AS condenser_avg,
+			COALESCE(MIN(water_level_on_condenser), 0) AS condenser_min,
+			COALESCE(MAX(water_level_on_condenser), 0) AS condenser_max,
+			COALESCE(AVG(water_level_on_nutrient_tank), 0) AS nutrient_avg,
+			COALESCE(MIN(water_level_on_nutrient_tank), 0) AS nutrient_min,
+			COALESCE(MAX(water_level_on_nutrient_tank), 0) AS nutrient_max,
+			COALESCE(AVG(humidity), 0) AS humidity_avg,
+			COALESCE(MIN(humidity), 0) AS humidity_min,
+			COALESCE(MAX(humidity), 0) AS humidity_max
+		FROM telemetries
+		WHERE device_id IN ? AND created_at >= ? AND created_at < ?`, deviceIDs, from, to).
+		Scan(&row).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.TelemetrySummary{
+		DeviceCount:              row.DeviceCount,
+		SampleCount:              row.SampleCount,
+		From:                     from,
+		To:                       to,
+		Ppm:                      models.MetricSummary{Avg: row.PpmAvg, Min: row.PpmMin, Max: row.PpmMax},
+		WaterLevelOnPlant:        models.MetricSummary{Avg: row.PlantAvg, Min: row.PlantMin, Max: row.PlantMax},
+		WaterLevelOnCondenser:    models.MetricSummary{Avg: row.CondenserAvg, Min: row.CondenserMin, Max: row.CondenserMax},
+		WaterLevelOnNutrientTank: models.MetricSummary{Avg: row.NutrientAvg, Min: row.NutrientMin, Max: row.NutrientMax},
+		Humidity:                 models.MetricSummary{Avg: row.HumidityAvg, Min: row.HumidityMin, Max: row.HumidityMax},
+	}, nil
+}
+
+func (r *telemetryRepo) Aggregate(q *models.AggregateQuery) ([]models.AggregateBucket, error) {
+	return aggregate(r.db, "telemetries", q)
+}
+
+func (r *telemetryRepo) DeleteByDeviceID(deviceID string) (int64, error) {
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("device_id = ?", deviceID).Delete(&TelemetryDedupKey{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("device_id = ?", deviceID).Delete(&Telemetry{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+// ArchiveByDeviceID moves the device's readings into telemetry_archives in
+// one transaction.
+func (r *telemetryRepo) ArchiveByDeviceID(deviceID string) (int64, error) {
+	var moved int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO telemetry_archives
+				(id, device_id, ppm, water_level_on_plant, water_level_on_condenser,
+				 water_level_on_nutrient_tank, humidity, created_at, metrics,
+				 sequence, idempotency_key, archived_at)
+			SELECT id, device_id, ppm, water_level_on_plant, water_level_on_condenser,
+				water_level_on_nutrient_tank, humidity, created_at, metrics,
+				sequence, idempotency_key, ?
+			FROM telemetries WHERE device_id = ?
+			ON CONFLICT (id) DO NOTHING`, time.Now(), deviceID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("device_id = ?", deviceID).Delete(&TelemetryDedupKey{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("device_id = ?", deviceID).Delete(&Telemetry{})
+		moved = res.RowsAffected
+		return res.Error
+	})
+	return moved, err
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+)
+
+const (
+	minAggregateBucket     = time.Minute
+	maxAggregateBuckets    = 10000
+	maxAggregateMetrics    = 10
+	maxAggregateFunctions  = 10
+	aggregateMaxWindowDays = 366
+)
+
+var ErrInvalidAggregate = errors.New("invalid aggregate query")
+
+// AggregateTelemetry summarizes a device's readings per time bucket. The
+// database does the aggregation; only one row per bucket is transferred.
+// When a rollup fits the query it is used instead of the raw readings, with
+// the window widened to whole rollup buckets.
+func (s *TelemetryService) AggregateTelemetry(ownerID string, q *models.AggregateQuery) (*models.AggregateResult, error) {
+	if err := s.validateAggregate(q); err != nil {
+		return nil, err
+	}
+	if err := s.AuthorizeDevice(ownerID, q.DeviceID); err != nil {
+		return nil, err
+	}
+
+	var buckets []models.AggregateBucket
+	var err error
+	resolution, size := rollupResolution(q)
+	if resolution == models.ResolutionRaw {
+		buckets, err = s.repo.Aggregate(q)
+	} else {
+		q.From = q.From.Truncate(size)
+		if to := q.To.Truncate(size); !to.Equal(q.To) {
+			q.To = to.Add(size)
+		}
+		buckets, err = s.rollups.Aggregate(resolution, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.AggregateResult{
+		DeviceID:   q.DeviceID,
+		From:       q.From.In(q.Location),
+		To:         q.To.In(q.Location),
+		Bucket:     q.Bucket.String(),
+		Timezone:   q.Location.String(),
+		Resolution: resolution,
+		Buckets:    buckets,
+	}, nil
+}
+
+func (s *TelemetryService) validateAggregate(q *models.AggregateQuery) error {
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidAggregate)
+	}
+	if q.To.Sub(q.From) > aggregateMaxWindowDays*24*time.Hour {
+		return fmt.Errorf("%w: window must not exceed %d days", ErrInvalidAggregate, aggregateMaxWindowDays)
+	}
+	if q.Bucket < minAggregateBucket || q.Bucket%time.Second != 0 {
+		return fmt.Errorf("%w: bucket must be whole seconds and at least %s", ErrInvalidAggregate, minAggregateBucket)
+	}
+	if q.To.Sub(q.From)/q.Bucket > maxAggregateBuckets {
+		return fmt.Errorf("%w: more than %d buckets, use a larger bucket", ErrInvalidAggregate, maxAggregateBuckets)
+	}
+
+	if len(q.Metrics) == 0 || len(q.Metrics) > maxAggregateMetrics {
+		return fmt.Errorf("%w: between 1 and %d metrics are required", ErrInvalidAggregate, maxAggregateMetrics)
+	}
+	for _, name := range q.Metrics {
+		known, err := s.metrics.Known(name)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown metric %q", ErrInvalidAggregate, name)
+		}
+	}
+
+	if len(q.Functions) == 0 || len(q.Functions) > maxAggregateFunctions {
+		return fmt.Errorf("%w: between 1 and %d functions are required", ErrInvalidAggregate, maxAggregateFunctions)
+	}
+	for _, fn := range q.Functions {
+		if !models.IsValidAggregateFunction(fn) {
+			return fmt.Errorf("%w: unknown function %q", ErrInvalidAggregate, fn)
+		}
+	}
+	return nil
+}
@@ -1,47 +1,223 @@
 package service
 
 import (
+	"encoding/json"
 	"log" // Tambahkan log untuk debugging
+	"sort"
+	"time"
 
 	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/pubsub"
 )
 
+// DefaultSubscriberBuffer is how many readings a subscriber may fall behind
+// before the slow-consumer policy applies.
+const DefaultSubscriberBuffer = 64
+
+// replayBufferSize is how many recent readings the broker keeps so that a
+// reconnecting client can catch up from its Last-Event-ID.
+const replayBufferSize = 256
+
+// SlowConsumerPolicy decides what happens when a subscriber's buffer is full.
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued reading to make room.
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// DropNewest discards the reading being published.
+	DropNewest SlowConsumerPolicy = "drop_newest"
+	// Disconnect closes the subscription; the client reconnects and resumes
+	// from its Last-Event-ID.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+func IsValidSlowConsumerPolicy(p string) bool {
+	switch SlowConsumerPolicy(p) {
+	case DropOldest, DropNewest, Disconnect:
+		return true
+	}
+	return false
+}
+
+// BrokerOptions configures a Broker. Zero values mean an in-memory backend,
+// DefaultSubscriberBuffer and DropOldest.
+type BrokerOptions struct {
+	Backend    BrokerBackend
+	BufferSize int
+	Policy     SlowConsumerPolicy
+}
+
+// Event is one telemetry reading on the stream. ID is assigned by the broker
+// and increases with every published reading; it is 0 for a reading the
+// backend failed to publish.
+type Event struct {
+	ID        uint64
+	Telemetry *models.Telemetry
+}
+
+// ClientSubscription is one client following one device. Closed is closed
+// when the broker disconnects a slow subscriber.
 type ClientSubscription struct {
-	Channel  chan *models.Telemetry
+	Channel  chan *Event
 	DeviceID string
+	Closed   chan struct{}
+
+	id          uint64
+	since       time.Time
+	enqueued    uint64
+	dropped     uint64
+	lastEventID uint64
+	replay      chan replay
+}
+
+// SubscriptionStats describes one subscription for the debug endpoint.
+type SubscriptionStats struct {
+	ID            uint64    `json:"id"`
+	DeviceID      string    `json:"device_id"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	QueueDepth    int       `json:"queue_depth"`
+	QueueCapacity int       `json:"queue_capacity"`
+	Enqueued      uint64    `json:"enqueued"`
+	Dropped       uint64    `json:"dropped"`
+}
+
+// replay is what a new subscriber missed since its Last-Event-ID. Resumed is
+// false when the ID is unknown or already evicted from the replay buffer.
+type replay struct {
+	Events  []*Event
+	Resumed bool
+}
+
+// BrokerBackend carries published readings between service instances. Every
+// instance, the publisher included, receives each message from Messages.
+type BrokerBackend interface {
+	Publish(payload []byte) error
+	Messages() <-chan pubsub.Message
 }
 
 type Broker struct {
 	Notifier chan *models.Telemetry
 
-	Clients map[string][]chan *models.Telemetry
+	Clients map[string][]*ClientSubscription
 
-	NewClients     chan ClientSubscription
-	ClosingClients chan ClientSubscription
+	NewClients     chan *ClientSubscription
+	ClosingClients chan *ClientSubscription
+
+	// backend is nil when readings only fan out inside this process. resync
+	// raises seq after the backend lost readings while reconnecting.
+	backend  BrokerBackend
+	incoming chan *Event
+	resync   chan uint64
+
+	// seq, history and nextID are only touched by listen.
+	seq     uint64
+	history []*Event
+	nextID  uint64
+	stats   chan chan []SubscriptionStats
+
+	bufferSize int
+	policy     SlowConsumerPolicy
 }
 
-func NewBroker() *Broker {
+func NewBroker(opts BrokerOptions) *Broker {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSubscriberBuffer
+	}
+	if opts.Policy == "" {
+		opts.Policy = DropOldest
+	}
 	b := &Broker{
 		Notifier:       make(chan *models.Telemetry, 1),
-		Clients:        make(map[string][]chan *models.Telemetry),
-		NewClients:     make(chan ClientSubscription),
-		ClosingClients: make(chan ClientSubscription),
+		Clients:        make(map[string][]*ClientSubscription),
+		NewClients:     make(chan *ClientSubscription),
+		ClosingClients: make(chan *ClientSubscription),
+		backend:        opts.Backend,
+		incoming:       make(chan *Event, 1),
+		resync:         make(chan uint64),
+		stats:          make(chan chan []SubscriptionStats),
+		bufferSize:     opts.BufferSize,
+		policy:         opts.Policy,
 	}
 	go b.listen()
+	if b.backend != nil {
+		go b.receive()
+	}
 	return b
 }
 
-func (b *Broker) removeClient(deviceID string, clientChan chan *models.Telemetry) {
-	clientChans, ok := b.Clients[deviceID]
+// Publish fans a reading out. Without a backend, or when the backend fails,
+// it goes to this instance's subscribers. It waits for the broker, which
+// never blocks on a subscriber, so only the slow-consumer policy drops
+// readings.
+func (b *Broker) Publish(t *models.Telemetry) {
+	if b.backend != nil {
+		payload, err := json.Marshal(t)
+		if err == nil {
+			err = b.backend.Publish(payload)
+		}
+		if err == nil {
+			return
+		}
+		log.Printf("Broker: backend publish failed, delivering locally: %v", err)
+	}
+	b.Notifier <- t
+}
+
+func (b *Broker) receive() {
+	for m := range b.backend.Messages() {
+		if m.Payload == nil {
+			b.resync <- m.ID
+			continue
+		}
+		var t models.Telemetry
+		if err := json.Unmarshal(m.Payload, &t); err != nil {
+			log.Printf("Broker: dropping undecodable event %d: %v", m.ID, err)
+			continue
+		}
+		b.incoming <- &Event{ID: m.ID, Telemetry: &t}
+	}
+}
+
+// Subscribe registers a client for one device's readings. A non-zero
+// lastEventID asks for the readings published after it; resumed reports
+// whether the gap could be filled completely. Call Unsubscribe when the
+// client goes away.
+func (b *Broker) Subscribe(deviceID string, lastEventID uint64) (sub *ClientSubscription, missed []*Event, resumed bool) {
+	sub = &ClientSubscription{
+		Channel:     make(chan *Event, b.bufferSize),
+		DeviceID:    deviceID,
+		Closed:      make(chan struct{}),
+		since:       time.Now(),
+		lastEventID: lastEventID,
+		replay:      make(chan replay, 1),
+	}
+	b.NewClients <- sub
+	r := <-sub.replay
+	return sub, r.Events, r.Resumed
+}
+
+func (b *Broker) Unsubscribe(sub *ClientSubscription) {
+	b.ClosingClients <- sub
+}
+
+// Subscriptions lists the active subscriptions, oldest first.
+func (b *Broker) Subscriptions() []SubscriptionStats {
+	reply := make(chan []SubscriptionStats, 1)
+	b.stats <- reply
+	return <-reply
+}
+
+func (b *Broker) removeClient(sub *ClientSubscription) {
+	subs, ok := b.Clients[sub.DeviceID]
 	if !ok {
 		return
 	}
 
-	for i, ch := range clientChans {
-		if ch == clientChan {
-			b.Clients[deviceID] = append(clientChans[:i], clientChans[i+1:]...)
-			if len(b.Clients[deviceID]) == 0 {
-				delete(b.Clients, deviceID)
+	for i, s := range subs {
+		if s == sub {
+			b.Clients[sub.DeviceID] = append(subs[:i], subs[i+1:]...)
+			if len(b.Clients[sub.DeviceID]) == 0 {
+				delete(b.Clients, sub.DeviceID)
 			}
 			return
 		}
@@ -52,23 +228,133 @@ func (b *Broker) listen() {
 	for {
 		select {
 		case newClient := <-b.NewClients:
-			b.Clients[newClient.DeviceID] = append(b.Clients[newClient.DeviceID], newClient.Channel)
+			b.nextID++
+			newClient.id = b.nextID
+			b.Clients[newClient.DeviceID] = append(b.Clients[newClient.DeviceID], newClient)
+			newClient.replay <- b.missed(newClient)
 			log.Printf("Broker: New client subscribed to DeviceID: %s. Total subs: %d", newClient.DeviceID, len(b.Clients[newClient.DeviceID]))
 
 		case closingClient := <-b.ClosingClients:
-			b.removeClient(closingClient.DeviceID, closingClient.Channel)
+			b.removeClient(closingClient)
 			log.Printf("Broker: Client unsubscribed from DeviceID: %s", closingClient.DeviceID)
 
+		case reply := <-b.stats:
+			reply <- b.subscriptionStats()
+
 		case data := <-b.Notifier:
-			if clientChans, ok := b.Clients[data.DeviceID]; ok {
-				for _, clientChan := range clientChans {
-					select {
-					case clientChan <- data:
-					default:
-						log.Println("Broker: Client channel full, dropping message.")
-					}
-				}
+			// A reading the backend failed to publish gets no ID: one of
+			// ours would collide with the shared sequence, so it is sent
+			// without one and never replayed.
+			ev := &Event{Telemetry: data}
+			if b.backend == nil {
+				b.seq++
+				ev.ID = b.seq
+			}
+			b.dispatch(ev)
+
+		case id := <-b.resync:
+			if id > b.seq {
+				b.seq = id
+			}
+
+		case ev := <-b.incoming:
+			// Backend IDs come from a shared sequence; keep seq at the
+			// highest one seen so Last-Event-ID checks stay valid.
+			if ev.ID > b.seq {
+				b.seq = ev.ID
 			}
+			b.dispatch(ev)
+		}
+	}
+}
+
+func (b *Broker) dispatch(ev *Event) {
+	if ev.ID != 0 {
+		if len(b.history) == replayBufferSize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, ev)
+	}
+
+	// deliver may remove subscribers, so walk a copy.
+	subs := append([]*ClientSubscription(nil), b.Clients[ev.Telemetry.DeviceID]...)
+	for _, sub := range subs {
+		b.deliver(sub, ev)
+	}
+}
+
+// deliver never blocks the broker: when sub is full the policy decides which
+// reading is lost, or whether sub is cut off.
+func (b *Broker) deliver(sub *ClientSubscription, ev *Event) {
+	select {
+	case sub.Channel <- ev:
+		sub.enqueued++
+		return
+	default:
+	}
+
+	sub.dropped++
+	switch b.policy {
+	case DropOldest:
+		select {
+		case <-sub.Channel:
+		default:
 		}
+		select {
+		case sub.Channel <- ev:
+			sub.enqueued++
+		default:
+		}
+	case Disconnect:
+		log.Printf("Broker: disconnecting slow client of DeviceID: %s", sub.DeviceID)
+		b.removeClient(sub)
+		close(sub.Closed)
+	}
+}
+
+func (b *Broker) subscriptionStats() []SubscriptionStats {
+	stats := []SubscriptionStats{}
+	for _, subs := range b.Clients {
+		for _, sub := range subs {
+			stats = append(stats, SubscriptionStats{
+				ID:            sub.id,
+				DeviceID:      sub.DeviceID,
+				ConnectedAt:   sub.since,
+				QueueDepth:    len(sub.Channel),
+				QueueCapacity: cap(sub.Channel),
+				Enqueued:      sub.enqueued,
+				Dropped:       sub.dropped,
+			})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// missed collects the readings sub has not seen yet. Registration and replay
+// happen in the same listen step, so nothing falls between them.
+func (b *Broker) missed(sub *ClientSubscription) replay {
+	if sub.lastEventID == 0 || sub.lastEventID > b.seq {
+		return replay{}
+	}
+	// Every reading after lastEventID must still be in history. A gap means
+	// readings were evicted or never reached this instance.
+	next := sub.lastEventID + 1
+	var events []*Event
+	for _, ev := range b.history {
+		if ev.ID <= sub.lastEventID {
+			continue
+		}
+		if ev.ID != next {
+			return replay{}
+		}
+		next++
+		if ev.Telemetry.DeviceID == sub.DeviceID {
+			events = append(events, ev)
+		}
+	}
+	if next != b.seq+1 {
+		return replay{}
 	}
+	return replay{Events: events, Resumed: true}
 }
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"log"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
+)
+
+// DeletePolicy decides what happens to a deleted device's readings.
+type DeletePolicy string
+
+const (
+	// DeletePurge removes the readings.
+	DeletePurge DeletePolicy = "purge"
+	// DeleteArchive moves the readings to telemetry_archives.
+	DeleteArchive DeletePolicy = "archive"
+)
+
+func IsValidDeletePolicy(p string) bool {
+	switch DeletePolicy(p) {
+	case DeletePurge, DeleteArchive:
+		return true
+	}
+	return false
+}
+
+// CleanupService reacts to devices being purged by device-service-v2.
+type CleanupService struct {
+	repo      repository.TelemetryRepository
+	rollups   repository.RollupRepository
+	retention repository.RetentionRepository
+	owners    *OwnershipResolver
+	policy    DeletePolicy
+}
+
+func NewCleanupService(r repository.TelemetryRepository, rollups repository.RollupRepository, retention repository.RetentionRepository, owners *OwnershipResolver, policy DeletePolicy) *CleanupService {
+	return &CleanupService{repo: r, rollups: rollups, retention: retention, owners: owners, policy: policy}
+}
+
+// DeviceDeleted applies the delete policy to deviceID's readings and reports
+// how many were removed from the live table. Rollups and the device's
+// retention override are dropped under either policy. Repeating it is
+// harmless.
+func (s *CleanupService) DeviceDeleted(deviceID string) (int64, error) {
+	s.owners.Forget(deviceID)
+
+	var n int64
+	var err error
+	switch s.policy {
+	case DeleteArchive:
+		n, err = s.repo.ArchiveByDeviceID(deviceID)
+	default:
+		n, err = s.repo.DeleteByDeviceID(deviceID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := s.rollups.DeleteByDeviceID(deviceID); err != nil {
+		return 0, err
+	}
+	if err := s.retention.DeleteOverride(deviceID); err != nil {
+		return 0, err
+	}
+	log.Printf("CleanupService: %s %d readings of deleted device %s", s.policy, n, deviceID)
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/client"
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+)
+
+var ErrGroupNotFound = errors.New("group not found")
+
+// groupDeviceIDs resolves the members of groupID through device-service-v2,
+// acting as the user behind authHeader.
+func (s *TelemetryService) groupDeviceIDs(authHeader, groupID string) ([]string, error) {
+	ids, err := s.devices.GroupDeviceIDs(authHeader, groupID)
+	if err != nil {
+		if errors.Is(err, client.ErrGroupNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *TelemetryService) GetTelemetryByGroupID(authHeader, groupID string, duration time.Duration) ([]*models.Telemetry, error) {
+	ids, err := s.groupDeviceIDs(authHeader, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*models.Telemetry{}, nil
+	}
+	return s.repo.GetTelemetryByDeviceIDs(duration, ids)
+}
+
+func (s *TelemetryService) GetLatestTelemetryByGroupID(authHeader, groupID string) ([]*models.Telemetry, error) {
+	ids, err := s.groupDeviceIDs(authHeader, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*models.Telemetry{}, nil
+	}
+	return s.repo.GetLatestTelemetryByDeviceIDs(ids)
+}
+
+func (s *TelemetryService) SummarizeTelemetryByGroupID(authHeader, groupID string, duration time.Duration) (*models.TelemetrySummary, error) {
+	ids, err := s.groupDeviceIDs(authHeader, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		now := time.Now()
+		return &models.TelemetrySummary{From: now.Add(-duration), To: now}, nil
+	}
+	return s.repo.SummarizeByDeviceIDs(duration, ids)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+)
+
+const (
+	defaultHistoryLimit = 500
+	maxHistoryLimit     = 5000
+)
+
+var ErrInvalidHistory = errors.New("invalid history query")
+
+// GetTelemetryByDeviceID returns one page of a device's readings. A query
+// that continues from a cursor keeps the cursor's window and order.
+func (s *TelemetryService) GetTelemetryByDeviceID(ownerID string, q *models.HistoryQuery) (*models.TelemetryPage, error) {
+	if q.After != nil {
+		q.From, q.To, q.Order = q.After.From, q.After.To, q.After.Order
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if err := validateHistory(q); err != nil {
+		return nil, err
+	}
+	if err := s.AuthorizeDevice(ownerID, q.DeviceID); err != nil {
+		return nil, err
+	}
+
+	// One extra row tells whether another page follows.
+	rows, err := s.repo.GetTelemetryByDeviceID(q, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.TelemetryPage{
+		Telemetries: rows,
+		Paging:      models.Paging{From: q.From, To: q.To, Order: q.Order, Limit: q.Limit},
+	}
+	if len(rows) > q.Limit {
+		page.Telemetries = rows[:q.Limit]
+		last := page.Telemetries[q.Limit-1]
+		page.Paging.HasMore = true
+		page.Paging.NextCursor = (&models.HistoryCursor{
+			From:      q.From,
+			To:        q.To,
+			Order:     q.Order,
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		}).Encode()
+	}
+	page.Paging.Count = len(page.Telemetries)
+	return page, nil
+}
+
+func validateHistory(q *models.HistoryQuery) error {
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidHistory)
+	}
+	if q.Order != models.OrderAsc && q.Order != models.OrderDesc {
+		return fmt.Errorf("%w: order must be asc or desc", ErrInvalidHistory)
+	}
+	if q.Limit < 1 || q.Limit > maxHistoryLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidHistory, maxHistoryLimit)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
+)
+
+const maxMetricPrecision = 10
+
+var (
+	ErrMetricNotFound = errors.New("metric not found")
+	ErrMetricExists   = errors.New("metric already exists")
+	ErrInvalidMetric  = errors.New("invalid metric")
+	ErrInvalidReading = errors.New("invalid reading")
+)
+
+// MetricService owns the metric catalogue. Ingest checks readings against an
+// in-memory copy that is reloaded after every change here and every refresh
+// interval, so edits made on another instance arrive within that interval.
+type MetricService struct {
+	repo repository.MetricRepository
+
+	mu        sync.RWMutex
+	catalogue map[string]*models.Metric
+}
+
+func NewMetricService(r repository.MetricRepository) *MetricService {
+	return &MetricService{repo: r}
+}
+
+func (s *MetricService) ListMetrics() ([]*models.Metric, error) {
+	return s.repo.FindAll()
+}
+
+func (s *MetricService) GetMetric(name string) (*models.Metric, error) {
+	m, err := s.repo.FindByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrMetricNotFound
+	}
+	return m, nil
+}
+
+func (s *MetricService) CreateMetric(m *models.Metric) (*models.Metric, error) {
+	if !models.ValidMetricName(m.Name) {
+		return nil, fmt.Errorf("%w: name must be lower_snake_case, at most 64 characters", ErrInvalidMetric)
+	}
+	if err := validateMetric(m); err != nil {
+		return nil, err
+	}
+	ex, err := s.repo.FindByName(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if ex != nil {
+		return nil, ErrMetricExists
+	}
+
+	row := repository.ToRepositoryMetric(m)
+	row.BuiltIn = false
+	if err := s.repo.Create(row); err != nil {
+		return nil, err
+	}
+	s.reload()
+	return row.ToDomain(), nil
+}
+
+func (s *MetricService) UpdateMetric(m *models.Metric) (*models.Metric, error) {
+	if err := validateMetric(m); err != nil {
+		return nil, err
+	}
+	updated, err := s.repo.Update(repository.ToRepositoryMetric(m))
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, ErrMetricNotFound
+	}
+	s.reload()
+	return updated, nil
+}
+
+func validateMetric(m *models.Metric) error {
+	if m.Min != nil && m.Max != nil && *m.Min > *m.Max {
+		return fmt.Errorf("%w: min must not exceed max", ErrInvalidMetric)
+	}
+	if m.Precision != nil && (*m.Precision < 0 || *m.Precision > maxMetricPrecision) {
+		return fmt.Errorf("%w: precision must be between 0 and %d", ErrInvalidMetric, maxMetricPrecision)
+	}
+	return nil
+}
+
+// RunRefresh reloads the catalogue every interval. It never returns.
+func (s *MetricService) RunRefresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.reload()
+	}
+}
+
+func (s *MetricService) reload() {
+	if _, err := s.load(); err != nil {
+		log.Printf("MetricService: reloading catalogue failed: %v", err)
+	}
+}
+
+func (s *MetricService) load() (map[string]*models.Metric, error) {
+	metrics, err := s.repo.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	catalogue := make(map[string]*models.Metric, len(metrics))
+	for _, m := range metrics {
+		catalogue[m.Name] = m
+	}
+	s.mu.Lock()
+	s.catalogue = catalogue
+	s.mu.Unlock()
+	return catalogue, nil
+}
+
+func (s *MetricService) current() (map[string]*models.Metric, error) {
+	s.mu.RLock()
+	catalogue := s.catalogue
+	s.mu.RUnlock()
+	if catalogue != nil {
+		return catalogue, nil
+	}
+	return s.load()
+}
+
+// Known reports whether name is in the catalogue.
+func (s *MetricService) Known(name string) (bool, error) {
+	catalogue, err := s.current()
+	if err != nil {
+		return false, err
+	}
+	return catalogue[name] != nil, nil
+}
+
+// NormalizeReading checks every value of t against the catalogue and rounds
+// it to the metric's precision. A rejected reading comes back with the
+// quarantine reason and an ErrInvalidReading describing the first problem.
+func (s *MetricService) NormalizeReading(t *models.Telemetry) (string, error) {
+	catalogue, err := s.current()
+	if err != nil {
+		return "", err
+	}
+
+	fields := map[string]*float64{
+		models.MetricPpm:                      &t.Ppm,
+		models.MetricWaterLevelOnPlant:        &t.WaterLevelOnPlant,
+		models.MetricWaterLevelOnCondenser:    &t.WaterLevelOnCondenser,
+		models.MetricWaterLevelOnNutrientTank: &t.WaterLevelOnNutrientTank,
+		models.MetricHumidity:                 &t.Humidity,
+	}
+	for name, v := range fields {
+		m := catalogue[name]
+		if m == nil {
+			continue
+		}
+		if !m.Check(*v) {
+			return models.RejectOutOfRange, fmt.Errorf("%w: %s is out of range", ErrInvalidReading, name)
+		}
+		*v = m.Round(*v)
+	}
+
+	for name, v := range t.Metrics {
+		if models.IsFieldMetric(name) {
+			return models.RejectUnknownMetric, fmt.Errorf("%w: send %s as a top-level field", ErrInvalidReading, name)
+		}
+		m := catalogue[name]
+		if m == nil {
+			return models.RejectUnknownMetric, fmt.Errorf("%w: unknown metric %q", ErrInvalidReading, name)
+		}
+		if !m.Check(v) {
+			return models.RejectOutOfRange, fmt.Errorf("%w: %s is out of range", ErrInvalidReading, name)
+		}
+		t.Metrics[name] = m.Round(v)
+	}
+	return "", nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/client"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+// maxCachedOwners bounds the owner cache. When it is full, expired entries
+// are swept first; misses are then no longer cached, and a known owner
+// evicts an arbitrary entry.
+const maxCachedOwners = 10000
+
+// OwnershipResolver answers "may this user read this device" for every
+// telemetry read and stream. Owners come from device-service-v2 and are
+// cached for ttl; unknown devices are cached for missTTL so a client polling
+// a bad ID does not hammer device-service-v2.
+type OwnershipResolver struct {
+	devices *client.DeviceClient
+	ttl     time.Duration
+	missTTL time.Duration
+
+	mu     sync.Mutex
+	owners map[string]ownerEntry
+}
+
+type ownerEntry struct {
+	ownerID string
+	expires time.Time
+}
+
+func NewOwnershipResolver(devices *client.DeviceClient, ttl time.Duration) *OwnershipResolver {
+	missTTL := ttl / 4
+	if missTTL > 10*time.Second {
+		missTTL = 10 * time.Second
+	}
+	return &OwnershipResolver{
+		devices: devices,
+		ttl:     ttl,
+		missTTL: missTTL,
+		owners:  make(map[string]ownerEntry),
+	}
+}
+
+// Authorize returns ErrDeviceNotFound unless ownerID owns deviceID, so
+// someone else's device looks exactly like a missing one.
+func (r *OwnershipResolver) Authorize(ownerID, deviceID string) error {
+	owner, err := r.owner(deviceID)
+	if err != nil {
+		return err
+	}
+	if ownerID == "" || owner != ownerID {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// Known reports whether device-service-v2 has deviceID registered to
+// someone.
+func (r *OwnershipResolver) Known(deviceID string) (bool, error) {
+	owner, err := r.owner(deviceID)
+	if err != nil {
+		return false, err
+	}
+	return owner != "", nil
+}
+
+// Forget drops the cached owner of deviceID, e.g. once the device is gone.
+func (r *OwnershipResolver) Forget(deviceID string) {
+	r.mu.Lock()
+	delete(r.owners, deviceID)
+	r.mu.Unlock()
+}
+
+// owner returns "" for devices device-service-v2 does not know.
+func (r *OwnershipResolver) owner(deviceID string) (string, error) {
+	now := time.Now()
+	r.mu.Lock()
+	e, ok := r.owners[deviceID]
+	r.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.ownerID, nil
+	}
+
+	owner, err := r.devices.DeviceOwner(deviceID)
+	ttl := r.ttl
+	if errors.Is(err, client.ErrDeviceNotFound) {
+		owner, err, ttl = "", nil, r.missTTL
+	}
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, cached := r.owners[deviceID]; !cached && len(r.owners) >= maxCachedOwners {
+		for id, e := range r.owners {
+			if now.After(e.expires) {
+				delete(r.owners, id)
+			}
+		}
+		if len(r.owners) >= maxCachedOwners {
+			// Ingest looks up any ID a sender makes up, so misses must not
+			// push out the owners of real devices.
+			if owner == "" {
+				return owner, nil
+			}
+			for id := range r.owners {
+				delete(r.owners, id)
+				break
+			}
+		}
+	}
+	r.owners[deviceID] = ownerEntry{ownerID: owner, expires: now.Add(ttl)}
+	return owner, nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
+)
+
+// PartitionService keeps monthly telemetry partitions created ahead of the
+// readings that will land in them. maxAge is how old an accepted reading may
+// be, so the months it reaches back into get a partition as well.
+type PartitionService struct {
+	repo        repository.PartitionRepository
+	monthsAhead int
+	maxAge      time.Duration
+}
+
+func NewPartitionService(r repository.PartitionRepository, monthsAhead int, maxAge time.Duration) *PartitionService {
+	return &PartitionService{repo: r, monthsAhead: monthsAhead, maxAge: maxAge}
+}
+
+// RunMaintenance creates the upcoming partitions every interval. It never
+// returns.
+func (s *PartitionService) RunMaintenance(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.repo.EnsurePartitions(time.Now().Add(-s.maxAge), s.monthsAhead); err != nil {
+			log.Printf("PartitionService: %v", err)
+		}
+	}
+}
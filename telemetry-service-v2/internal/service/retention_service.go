@@ -0,0 +1,332 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
+	"github.com/DXR3IN/telemetry-service-v2/internal/storage"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidRetention = errors.New("invalid retention policy")
+
+// RetentionOptions configure the retention worker. MinRawAge keeps raw
+// readings at least as long as late readings are accepted, so a late
+// reading never rolls up an hour whose other readings are gone.
+type RetentionOptions struct {
+	Global    models.RetentionPolicy
+	MinRawAge time.Duration
+	BatchSize int
+	// Archive receives raw readings before they are deleted; nil deletes
+	// them without a copy.
+	Archive storage.ArchiveStore
+	// QuarantineDays and QuarantineMaxRows bound quarantined readings by
+	// age and count; 0 disables the bound. Counters of device IDs that were
+	// only ever rejected expire with QuarantineDays too.
+	QuarantineDays    int
+	QuarantineMaxRows int
+}
+
+// RetentionService applies the global retention policy and per-device
+// overrides to raw readings and rollups.
+type RetentionService struct {
+	repo       repository.RetentionRepository
+	partitions repository.PartitionRepository
+	ingest     repository.IngestRepository
+	owners     *OwnershipResolver
+	opts       RetentionOptions
+}
+
+func NewRetentionService(r repository.RetentionRepository, partitions repository.PartitionRepository, ingest repository.IngestRepository, owners *OwnershipResolver, opts RetentionOptions) *RetentionService {
+	return &RetentionService{repo: r, partitions: partitions, ingest: ingest, owners: owners, opts: opts}
+}
+
+func (s *RetentionService) GetDeviceRetention(ownerID, deviceID string) (*models.DeviceRetention, error) {
+	if err := s.owners.Authorize(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	override, err := s.repo.FindOverride(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.repo.DeviceStorage(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DeviceRetention{
+		DeviceID: deviceID,
+		Policy:   override.Apply(s.opts.Global),
+		Override: override,
+		Storage:  usage,
+	}, nil
+}
+
+// SetDeviceRetention replaces the device's override; an empty override
+// returns the device to the global policy.
+func (s *RetentionService) SetDeviceRetention(ownerID, deviceID string, o *models.RetentionOverride) (*models.DeviceRetention, error) {
+	if err := s.owners.Authorize(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	if err := o.Apply(s.opts.Global).Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRetention, err)
+	}
+	var err error
+	if o.Empty() {
+		err = s.repo.DeleteOverride(deviceID)
+	} else {
+		err = s.repo.SaveOverride(deviceID, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDeviceRetention(ownerID, deviceID)
+}
+
+func (s *RetentionService) StorageUsage() (*models.StorageUsage, error) {
+	tables, err := s.repo.TableUsage()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := s.repo.CountOverrides()
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := s.partitions.ListPartitions()
+	if err != nil {
+		return nil, err
+	}
+	usage := &models.StorageUsage{Policy: s.opts.Global, DeviceOverrides: overrides, Tables: tables, Partitions: partitions}
+	if s.opts.Archive != nil {
+		usage.ArchiveEnabled = true
+		if usage.ArchiveFiles, usage.ArchiveBytes, err = s.opts.Archive.Usage(); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// RunRetention enforces the policies every interval. It never returns.
+func (s *RetentionService) RunRetention(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.enforce(time.Now())
+	}
+}
+
+func (s *RetentionService) enforce(now time.Time) {
+	s.expireQuarantine(now)
+
+	overrides, err := s.repo.FindOverrides()
+	if err != nil {
+		log.Printf("RetentionService: loading overrides failed: %v", err)
+		return
+	}
+	resolutions := []string{models.ResolutionRaw, models.ResolutionHourly, models.ResolutionDaily}
+
+	// Whole months go first; the batched deletes below only trim the rest.
+	s.dropPartitions(overrides, now)
+
+	// The global policy covers every device without its own value.
+	for _, res := range resolutions {
+		s.expire(res, "", s.opts.Global.Days(res), now)
+	}
+	for deviceID, o := range overrides {
+		p := o.Apply(s.opts.Global)
+		for _, res := range resolutions {
+			if o.Overrides(res) {
+				s.expire(res, deviceID, p.Days(res), now)
+			}
+		}
+	}
+}
+
+// expireQuarantine applies the quarantine bounds. Unlike telemetry they hold
+// whatever unknown senders post, so they are trimmed regardless of the
+// per-device policies.
+func (s *RetentionService) expireQuarantine(now time.Time) {
+	var steps []func() (int64, error)
+	if s.opts.QuarantineDays > 0 {
+		before := now.Add(-time.Duration(s.opts.QuarantineDays) * 24 * time.Hour)
+		steps = append(steps,
+			func() (int64, error) { return s.ingest.DeleteQuarantinedBefore(before, s.opts.BatchSize) },
+			func() (int64, error) { return s.ingest.DeleteIdleRejectedStats(before, s.opts.BatchSize) })
+	}
+	if s.opts.QuarantineMaxRows > 0 {
+		steps = append(steps, func() (int64, error) {
+			return s.ingest.TrimQuarantined(s.opts.QuarantineMaxRows, s.opts.BatchSize)
+		})
+	}
+	var total int64
+	for _, step := range steps {
+		for {
+			n, err := step()
+			if err != nil {
+				log.Printf("RetentionService: trimming quarantine failed: %v", err)
+				break
+			}
+			total += n
+			if n < int64(s.opts.BatchSize) {
+				break
+			}
+		}
+	}
+	if total > 0 {
+		log.Printf("RetentionService: removed %d quarantine rows", total)
+	}
+}
+
+// rawCutoff is the time before which raw readings kept for days are
+// removed.
+func (s *RetentionService) rawCutoff(days int, now time.Time) time.Time {
+	age := time.Duration(days) * 24 * time.Hour
+	if age < s.opts.MinRawAge {
+		age = s.opts.MinRawAge
+	}
+	return now.Add(-age)
+}
+
+// dropPartitions drops the monthly partitions whose readings every device
+// has let expire, archiving them first when an archive is configured.
+func (s *RetentionService) dropPartitions(overrides map[string]*models.RetentionOverride, now time.Time) {
+	days := s.opts.Global.RawDays
+	if days == 0 {
+		return
+	}
+	for _, o := range overrides {
+		p := o.Apply(s.opts.Global)
+		if p.RawDays == 0 {
+			return
+		}
+		if p.RawDays > days {
+			days = p.RawDays
+		}
+	}
+	before := s.rawCutoff(days, now)
+
+	partitions, err := s.partitions.ListPartitions()
+	if err != nil {
+		log.Printf("RetentionService: listing partitions failed: %v", err)
+		return
+	}
+	for _, p := range partitions {
+		if p.To == nil || p.To.After(before) {
+			continue
+		}
+		// Dropping an hour before its rollup is computed would lose it.
+		pending, err := s.partitions.HasPendingRollups(p)
+		if err != nil || pending {
+			if err != nil {
+				log.Printf("RetentionService: checking partition %s failed: %v", p.Name, err)
+			}
+			continue
+		}
+		if s.opts.Archive != nil {
+			if err := s.archivePartition(p); err != nil {
+				log.Printf("RetentionService: archiving partition %s failed: %v", p.Name, err)
+				continue
+			}
+		}
+		if err := s.partitions.DropPartition(p); err != nil {
+			log.Printf("RetentionService: dropping partition %s failed: %v", p.Name, err)
+			continue
+		}
+		log.Printf("RetentionService: dropped partition %s", p.Name)
+	}
+}
+
+// archivePartition copies a partition to the archive in files of BatchSize
+// readings.
+func (s *RetentionService) archivePartition(p models.TelemetryPartition) error {
+	var afterTime time.Time
+	var afterID string
+	for {
+		rows, err := s.partitions.ReadPartition(p.Name, afterTime, afterID, s.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := s.archive(rows); err != nil {
+			return err
+		}
+		last := rows[len(rows)-1]
+		afterTime, afterID = last.CreatedAt, last.ID
+	}
+}
+
+func (s *RetentionService) archive(rows []*repository.Telemetry) error {
+	readings := make([]*models.Telemetry, len(rows))
+	for i, row := range rows {
+		readings[i] = row.ToDomain()
+	}
+	name := fmt.Sprintf("raw-%s-%s.jsonl.gz", rows[0].CreatedAt.UTC().Format("20060102T150405Z"), uuid.New().String())
+	if _, err := s.opts.Archive.Write(name, readings); err != nil {
+		return fmt.Errorf("archiving: %w", err)
+	}
+	return nil
+}
+
+// expire deletes, batch by batch, what is older than days at resolution.
+func (s *RetentionService) expire(resolution, deviceID string, days int, now time.Time) {
+	if days == 0 {
+		return
+	}
+	before := now.Add(-time.Duration(days) * 24 * time.Hour)
+	if resolution == models.ResolutionRaw {
+		before = s.rawCutoff(days, now)
+	}
+
+	scope := deviceID
+	if scope == "" {
+		scope = "global policy"
+	}
+	var total int64
+	for {
+		var n int64
+		var err error
+		if resolution == models.ResolutionRaw {
+			n, err = s.expireRaw(deviceID, before)
+		} else {
+			n, err = s.repo.DeleteExpiredRollups(resolution, deviceID, before, s.opts.BatchSize)
+		}
+		if err != nil {
+			log.Printf("RetentionService: expiring %s data (%s) failed: %v", resolution, scope, err)
+			break
+		}
+		total += n
+		if n < int64(s.opts.BatchSize) {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("RetentionService: removed %d %s rows (%s)", total, resolution, scope)
+	}
+}
+
+// expireRaw deletes one batch of raw readings, archiving them first when an
+// archive is configured.
+func (s *RetentionService) expireRaw(deviceID string, before time.Time) (int64, error) {
+	rows, err := s.repo.FindExpiredRaw(deviceID, before, s.opts.BatchSize)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	if s.opts.Archive != nil {
+		if err := s.archive(rows); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.repo.DeleteRaw(ids); err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
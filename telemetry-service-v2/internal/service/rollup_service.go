@@ -0,0 +1,101 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
+)
+
+// rollupBatchSize is how many dirty buckets one query fetches.
+const rollupBatchSize = 500
+
+// RollupService keeps the hourly and daily rollups up to date. Ingest marks
+// the hours it writes to, including hours in the past when a device uploads
+// late readings; every run recomputes those hours and then their days.
+type RollupService struct {
+	repo repository.RollupRepository
+}
+
+func NewRollupService(r repository.RollupRepository) *RollupService {
+	return &RollupService{repo: r}
+}
+
+// RunRollups refreshes dirty rollups every interval. It never returns.
+func (s *RollupService) RunRollups(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		hours := s.refresh(models.ResolutionHourly, s.repo.RefreshHour)
+		days := s.refresh(models.ResolutionDaily, s.repo.RefreshDay)
+		if hours > 0 || days > 0 {
+			log.Printf("RollupService: refreshed %d hourly and %d daily rollups", hours, days)
+		}
+	}
+}
+
+func (s *RollupService) refresh(resolution string, fn func(*repository.TelemetryRollupDirty) error) int {
+	done := 0
+	for {
+		dirty, err := s.repo.FindDirty(resolution, rollupBatchSize)
+		if err != nil {
+			log.Printf("RollupService: listing dirty %s rollups failed: %v", resolution, err)
+			return done
+		}
+		failed := 0
+		for _, d := range dirty {
+			if err := fn(d); err != nil {
+				log.Printf("RollupService: refreshing %s rollup of %s at %s failed: %v", resolution, d.DeviceID, d.BucketStart, err)
+				failed++
+				continue
+			}
+			done++
+		}
+		// Failed markers stay and would be fetched again; leave them for
+		// the next run.
+		if len(dirty) < rollupBatchSize || failed > 0 {
+			return done
+		}
+	}
+}
+
+// rollupResolution picks the coarsest rollup that yields the same buckets as
+// the raw readings. Rollups are in UTC hours and days, so the bucket has to
+// be a multiple of the rollup and the time zone's offset a multiple of it
+// for the whole window. Percentiles cannot be combined and always use raw.
+func rollupResolution(q *models.AggregateQuery) (string, time.Duration) {
+	for _, fn := range q.Functions {
+		if _, ok := models.Percentile(fn); ok {
+			return models.ResolutionRaw, 0
+		}
+	}
+	for _, r := range []struct {
+		name string
+		size time.Duration
+	}{
+		{models.ResolutionDaily, 24 * time.Hour},
+		{models.ResolutionHourly, time.Hour},
+	} {
+		if q.Bucket%r.size == 0 && offsetsAligned(q.Location, q.From, q.To, r.size) {
+			return r.name, r.size
+		}
+	}
+	return models.ResolutionRaw, 0
+}
+
+func offsetsAligned(loc *time.Location, from, to time.Time, step time.Duration) bool {
+	t := from.In(loc)
+	for t.Before(to) {
+		_, offset := t.Zone()
+		if (time.Duration(offset)*time.Second)%step != 0 {
+			return false
+		}
+		_, end := t.ZoneBounds()
+		if end.IsZero() {
+			break
+		}
+		t = end
+	}
+	return true
+}
@@ -1,89 +1,280 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"time"
 
+	"github.com/DXR3IN/telemetry-service-v2/internal/client"
 	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
 	"github.com/DXR3IN/telemetry-service-v2/internal/repository"
 	"github.com/DXR3IN/telemetry-service-v2/internal/utils"
 	"github.com/google/uuid"
 )
 
+const (
+	// quarantineListLimit caps how many quarantined readings or ingest
+	// counters one request returns.
+	quarantineListLimit = 500
+	// maxIdempotencyKeyLen matches the idempotency_key column.
+	maxIdempotencyKeyLen = 255
+)
+
 var (
-	ErrTelemetryNotFound = errors.New("telemetry not found")
+	ErrUnknownDevice       = errors.New("unknown device")
+	ErrRegistryUnavailable = errors.New("device registry unavailable")
+	ErrEmptyBatch          = errors.New("batch is empty")
+	ErrBatchTooLarge       = errors.New("batch too large")
+	ErrInvalidTimestamp    = errors.New("invalid timestamp")
+	ErrInvalidIdempotency  = errors.New("idempotency key too long")
 )
 
+// IngestOptions bound batch uploads. Device timestamps further ahead of or
+// behind the server clock than allowed are rejected as clock skew.
+type IngestOptions struct {
+	MaxBatchSize   int
+	MaxClockAhead  time.Duration
+	MaxClockBehind time.Duration
+}
+
+func (o IngestOptions) checkTimestamp(at, now time.Time) error {
+	if at.After(now.Add(o.MaxClockAhead)) {
+		return fmt.Errorf("%w: more than %s ahead of server time", ErrInvalidTimestamp, o.MaxClockAhead)
+	}
+	if at.Before(now.Add(-o.MaxClockBehind)) {
+		return fmt.Errorf("%w: more than %s behind server time", ErrInvalidTimestamp, o.MaxClockBehind)
+	}
+	return nil
+}
+
 type TelemetryService struct {
-	repo   repository.TelemetryRepository
-	jwt    *utils.JWTManager
-	Broker *Broker
+	repo    repository.TelemetryRepository
+	rollups repository.RollupRepository
+	ingest  repository.IngestRepository
+	metrics *MetricService
+	devices *client.DeviceClient
+	owners  *OwnershipResolver
+	jwt     *utils.JWTManager
+	opts    IngestOptions
+	Broker  *Broker
 }
 
-func NewTelemetryService(r repository.TelemetryRepository, jwt *utils.JWTManager) *TelemetryService {
-	broker := NewBroker()
-	return &TelemetryService{repo: r, jwt: jwt, Broker: broker}
+func NewTelemetryService(r repository.TelemetryRepository, rollups repository.RollupRepository, ingest repository.IngestRepository, metrics *MetricService, devices *client.DeviceClient, owners *OwnershipResolver, jwt *utils.JWTManager, broker BrokerOptions, opts IngestOptions) *TelemetryService {
+	return &TelemetryService{repo: r, rollups: rollups, ingest: ingest, metrics: metrics, devices: devices, owners: owners, jwt: jwt, opts: opts, Broker: NewBroker(broker)}
 }
 
-func (s *TelemetryService) GetTelemetryByDeviceID(duration time.Duration, deviceID string) ([]*models.Telemetry, error) {
-	// ex, err := s.deviceRepo.FindByID(deviceID)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// if ex != nil {
-	// 	return nil, ErrDeviceExists
-	// }
-	// t is a telemetry variable
-	t, err := s.repo.GetTelemetryByDeviceID(duration, deviceID)
-	if t == nil {
-		return nil, ErrTelemetryNotFound
+// AuthorizeDevice must pass before a caller may read or stream a device's
+// telemetry.
+func (s *TelemetryService) AuthorizeDevice(ownerID, deviceID string) error {
+	return s.owners.Authorize(ownerID, deviceID)
+}
+
+// InsertTelemetry stores a reading from a registered device. Readings from
+// devices device-service-v2 does not know, or cannot confirm, and readings
+// with metrics the catalogue rejects are quarantined instead. A reading the
+// device already sent with the same sequence number or idempotency key is
+// not stored again: the original comes back with false.
+func (s *TelemetryService) InsertTelemetry(t *models.Telemetry) (*models.Telemetry, bool, error) {
+	if len(t.IdempotencyKey) > maxIdempotencyKeyLen {
+		return nil, false, ErrInvalidIdempotency
+	}
+	now := time.Now()
+	reason, err := s.admit(t)
+	if reason != "" {
+		return nil, false, s.quarantine(t, reason, err, now)
 	}
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return t, nil
-}
 
-func (s *TelemetryService) InsertTelemetry(t *models.Telemetry) (*models.Telemetry, error) {
-	// deviceID := t.DeviceID
-	// ex, err := s.deviceRepo.FindByID(deviceID)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// if ex != nil {
-	// 	return nil, ErrDeviceExists
-	// }
 	t.ID = uuid.New().String()
 	repoData := repository.ToRepository(t)
 
-	insertedRepoData, err := s.repo.TelemetryInserted(repoData)
+	insertedRepoData, inserted, err := s.repo.TelemetryInserted(repoData)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	data := insertedRepoData.ToDomain()
+	if !inserted {
+		return data, false, nil
+	}
+	if err := s.ingest.CountAccepted(t.DeviceID, 1, now); err != nil {
+		log.Printf("TelemetryService: counting reading of %s failed: %v", t.DeviceID, err)
+	}
+
+	s.Broker.Publish(data)
+
+	return data, true, nil
+}
+
+// InsertTelemetryBatch stores readings a device buffered while offline. Each
+// reading is checked like InsertTelemetry, plus its timestamp against the
+// allowed clock skew; rejected readings are quarantined and reported per
+// item, and the accepted ones are stored in one transaction. Readings stored
+// before are reported as duplicates with the original ID. Only the newest new
+// reading of each device is streamed.
+func (s *TelemetryService) InsertTelemetryBatch(readings []models.TelemetryReading) (*models.BatchResult, error) {
+	if len(readings) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(readings) > s.opts.MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	now := time.Now()
+	result := &models.BatchResult{Results: make([]models.BatchItemResult, len(readings))}
+	rows := make([]*repository.Telemetry, 0, len(readings))
+	positions := make([]int, 0, len(readings))
+
+	for i := range readings {
+		r := &readings[i]
+		t := &r.Telemetry
+		t.CreatedAt = now
+		if r.Timestamp != nil {
+			t.CreatedAt = *r.Timestamp
+		}
+		if len(t.IdempotencyKey) > maxIdempotencyKeyLen {
+			result.Rejected++
+			result.Results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemRejected, Error: ErrInvalidIdempotency.Error()}
+			continue
+		}
+
+		reason, err := s.admit(t)
+		if reason == "" && err == nil {
+			if err = s.opts.checkTimestamp(t.CreatedAt, now); err != nil {
+				reason = models.RejectClockSkew
+			}
+		}
+		if reason != "" {
+			if qerr := s.quarantine(t, reason, err, now); qerr != err {
+				return nil, qerr
+			}
+			result.Rejected++
+			result.Results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemRejected, Reason: reason, Error: err.Error()}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t.ID = uuid.New().String()
+		row := repository.ToRepository(t)
+		row.CreatedAt = t.CreatedAt
+		rows = append(rows, row)
+		positions = append(positions, i)
+	}
+
+	if len(rows) == 0 {
+		return result, nil
+	}
+	stored, inserted, err := s.repo.InsertBatch(rows)
+	if err != nil {
+		return nil, err
+	}
 
-	select {
-	case s.Broker.Notifier <- data:
-	default:
-		// Opsional: 
+	counts := make(map[string]int64)
+	newest := make(map[string]*models.Telemetry)
+	for j, row := range stored {
+		i := positions[j]
+		if !inserted[j] {
+			result.Duplicates++
+			result.Results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemDuplicate, ID: row.ID}
+			continue
+		}
+		result.Accepted++
+		result.Results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemAccepted, ID: row.ID}
+
+		counts[row.DeviceID]++
+		if n := newest[row.DeviceID]; n == nil || row.CreatedAt.After(n.CreatedAt) {
+			newest[row.DeviceID] = row.ToDomain()
+		}
+	}
+	for deviceID, n := range counts {
+		if err := s.ingest.CountAccepted(deviceID, n, now); err != nil {
+			log.Printf("TelemetryService: counting readings of %s failed: %v", deviceID, err)
+		}
 	}
+	for _, t := range newest {
+		s.Broker.Publish(t)
+	}
+	return result, nil
+}
 
-	return data, nil
+// admit runs the checks every reading must pass. A rejected reading comes
+// back with its quarantine reason and the error to report; any other error
+// means the checks could not run.
+func (s *TelemetryService) admit(t *models.Telemetry) (string, error) {
+	known, err := s.owners.Known(t.DeviceID)
+	if err != nil {
+		log.Printf("TelemetryService: device lookup for %q failed: %v", t.DeviceID, err)
+		return models.RejectRegistryUnavailable, ErrRegistryUnavailable
+	}
+	if !known {
+		return models.RejectUnknownDevice, ErrUnknownDevice
+	}
+	reason, err := s.metrics.NormalizeReading(t)
+	if err != nil && !errors.Is(err, ErrInvalidReading) {
+		return "", err
+	}
+	return reason, err
 }
 
 var TelemetryStream = make(chan *models.Telemetry)
 
-func (s *TelemetryService) GetLatestTelemetryByDeviceID(deviceID string) (*models.Telemetry, error) {
-	// ex, err := s.deviceRepo.FindByID(deviceID)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// if ex != nil {
-	// 	return nil, ErrDeviceNotFound
-	// }
+func (s *TelemetryService) GetLatestTelemetryByDeviceID(ownerID, deviceID string) (*models.Telemetry, error) {
+	if err := s.AuthorizeDevice(ownerID, deviceID); err != nil {
+		return nil, err
+	}
 	telemetries, err := s.repo.GetLatestTelemetryByDeviceID(deviceID)
 	if err != nil {
 		return nil, err
 	}
 	return telemetries, nil
 }
+
+// quarantine keeps a rejected reading and returns reason as the error, or
+// the storage error when the reading could not even be quarantined.
+func (s *TelemetryService) quarantine(t *models.Telemetry, reason string, rejected error, at time.Time) error {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	q := &repository.QuarantinedTelemetry{
+		ID:         uuid.New().String(),
+		DeviceID:   t.DeviceID,
+		Reason:     reason,
+		Payload:    string(payload),
+		ReceivedAt: at,
+	}
+	if err := s.ingest.Quarantine(q); err != nil {
+		return err
+	}
+	return rejected
+}
+
+// GetIngestStats returns the owner's view of what a device has sent.
+func (s *TelemetryService) GetIngestStats(ownerID, deviceID string) (*models.IngestStats, error) {
+	if err := s.AuthorizeDevice(ownerID, deviceID); err != nil {
+		return nil, err
+	}
+	stats, err := s.ingest.FindStats(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		stats = &models.IngestStats{DeviceID: deviceID}
+	}
+	return stats, nil
+}
+
+// ListQuarantine is for operators; it is not limited to one owner because
+// most quarantined readings belong to no one.
+func (s *TelemetryService) ListQuarantine(deviceID, reason string) ([]*models.QuarantinedTelemetry, error) {
+	return s.ingest.FindQuarantined(deviceID, reason, quarantineListLimit)
+}
+
+func (s *TelemetryService) ListIngestStats() ([]*models.IngestStats, error) {
+	return s.ingest.FindAllStats(quarantineListLimit)
+}
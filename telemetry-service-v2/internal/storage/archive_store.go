@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	models "github.com/DXR3IN/telemetry-service-v2/internal/domain"
+)
+
+// ArchiveStore keeps raw readings that retention removes from the database.
+// LocalArchiveStore is the only implementation today.
+type ArchiveStore interface {
+	Write(name string, rows []*models.Telemetry) (int64, error)
+	Usage() (files int64, bytes int64, err error)
+}
+
+// LocalArchiveStore writes each batch as a gzip-compressed JSON Lines file
+// below root.
+type LocalArchiveStore struct {
+	root string
+}
+
+func NewLocalArchiveStore(root string) (*LocalArchiveStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalArchiveStore{root: root}, nil
+}
+
+// Write stores rows under name and returns the compressed size. The file is
+// synced before it appears under name, so readings are only deleted once
+// their archive is on disk.
+func (s *LocalArchiveStore) Write(name string, rows []*models.Telemetry) (int64, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return 0, errors.New("invalid archive name")
+	}
+	tmp, err := os.CreateTemp(s.root, ".archive-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp.Name(), filepath.Join(s.root, name))
+}
+
+func (s *LocalArchiveStore) Usage() (int64, int64, error) {
+	var files, bytes int64
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes, err
+}